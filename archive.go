@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/klauspost/compress/zstd"
+)
+
+const ARCHIVES_DIR = "archives"
+const ARCHIVE_EXTENSION = ".tar.zst"
+const DEFAULT_ARCHIVES_KEPT = 10
+
+// archive_files saves the files a bulk operation is about to replace or
+// delete in a tar.zst archive of the state directory, named after the
+// operation, before it touches any of them. Paths are stored relative to the
+// root so the archive is restored with `tar --zstd -xf <archive> -C /`. It
+// returns an empty path when there is nothing to archive.
+func archive_files(operation string, files []string) (string, error) {
+	if len(files) == 0 {
+		return "", nil
+	}
+	stateDir, err := get_state_dir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(stateDir, ARCHIVES_DIR)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	archive := filepath.Join(dir, operation+"-"+time.Now().Format("20060102-150405")+ARCHIVE_EXTENSION)
+	tmp, err := os.CreateTemp(dir, filepath.Base(archive)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write_archive(tmp, files); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), archive); err != nil {
+		return "", err
+	}
+	prune_archives(dir)
+	return archive, nil
+}
+
+func write_archive(w io.Writer, files []string) error {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	for _, file := range files {
+		if err := add_to_archive(tw, file); err != nil {
+			zw.Close()
+			return fmt.Errorf("archiving %s: %w", file, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+func add_to_archive(tw *tar.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	hdr.Name = strings.TrimPrefix(filepath.ToSlash(abs), "/")
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// prune_archives keeps the newest archives, as many as archives_kept in the
+// configuration says. Errors are only logged, the new archive being written.
+func prune_archives(dir string) {
+	kept, err := strconv.Atoi(setting_value("archives_kept"))
+	if err != nil || kept < 1 {
+		log.Warn("Invalid archives_kept in the configuration, keeping the default", "err", err)
+		kept = DEFAULT_ARCHIVES_KEPT
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Warn("Error while pruning archives", "err", err)
+		return
+	}
+	type archiveFile struct {
+		name    string
+		modTime time.Time
+	}
+	var archives []archiveFile
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ARCHIVE_EXTENSION) {
+			if info, err := e.Info(); err == nil {
+				archives = append(archives, archiveFile{e.Name(), info.ModTime()})
+			}
+		}
+	}
+	if len(archives) <= kept {
+		return
+	}
+	slices.SortFunc(archives, func(a, b archiveFile) int { return b.modTime.Compare(a.modTime) })
+	for _, a := range archives[kept:] {
+		if err := os.Remove(filepath.Join(dir, a.name)); err != nil {
+			log.Warn("Error while pruning archives", "archive", a.name, "err", err)
+			continue
+		}
+		log.Debug("Old archive removed", "archive", a.name)
+	}
+}
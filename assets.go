@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+const DEFAULT_ASSETS = "cover,banner"
+
+// assetType names a kind of artwork, as written in --assets, the state files
+// and the reports.
+type assetType string
+
+const (
+	ASSET_COVER  assetType = "cover"
+	ASSET_BANNER assetType = "banner"
+	ASSET_ICON   assetType = "icon"
+	ASSET_HERO   assetType = "hero"
+	ASSET_LOGO   assetType = "logo"
+)
+
+// assetKind describes one kind of artwork, the SteamGridDB endpoint it comes
+// from and what an acceptable image for it looks like.
+type assetKind struct {
+	Name       assetType
+	Endpoint   string
+	Dimensions string
+	Width      int
+	// Mimes are the formats Lutris reads for the asset type, the first one
+	// being what others are transcoded to, formats.go having the policy.
+	Mimes []string
+	// Animated allows animated images, MaxWidth and MaxHeight bound their
+	// size, DEFAULT_MAX_DIMENSION when unset.
+	Animated  bool
+	MaxWidth  int
+	MaxHeight int
+	// TargetWidth and TargetHeight are the size images get with --resize.
+	TargetWidth  int
+	TargetHeight int
+	// DbFlag is the column of the Lutris games table telling the game has a
+	// custom image of this kind.
+	DbFlag string
+}
+
+var ASSET_KINDS = []assetKind{
+	{Name: ASSET_COVER, Endpoint: "grids", Dimensions: SGDB_COVER_FORMAT, Width: SGDB_COVER_WIDTH, Mimes: []string{MIME_TYPE_JPEG, MIME_TYPE_PNG}, TargetWidth: 600, TargetHeight: 900, DbFlag: "has_custom_coverart_big"},
+	{Name: ASSET_BANNER, Endpoint: "grids", Dimensions: SGDB_BANNER_FORMAT, Width: SGDB_BANNER_WIDTH, Mimes: []string{MIME_TYPE_JPEG, MIME_TYPE_PNG}, TargetWidth: 920, TargetHeight: 430, DbFlag: "has_custom_banner"},
+	{Name: ASSET_ICON, Endpoint: "icons", Mimes: []string{MIME_TYPE_PNG}, TargetWidth: 128, TargetHeight: 128, DbFlag: "has_custom_icon"},
+	{Name: ASSET_HERO, Endpoint: "heroes", Mimes: []string{MIME_TYPE_JPEG, MIME_TYPE_PNG}, TargetWidth: 1920, TargetHeight: 620},
+	{Name: ASSET_LOGO, Endpoint: "logos", Mimes: []string{MIME_TYPE_PNG}},
+}
+
+func parse_asset_kinds(list string) ([]assetKind, error) {
+	var kinds []assetKind
+	for _, field := range strings.Split(list, ",") {
+		name := assetType(strings.TrimSpace(field))
+		if name == "" {
+			continue
+		}
+		kind, ok := asset_kind(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown asset type %q", name)
+		}
+		if !slices.ContainsFunc(kinds, func(k assetKind) bool { return k.Name == name }) {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return nil, fmt.Errorf("no asset type selected")
+	}
+	return kinds, nil
+}
+
+func asset_kind(name assetType) (assetKind, bool) {
+	idx := slices.IndexFunc(ASSET_KINDS, func(k assetKind) bool { return k.Name == name })
+	if idx < 0 {
+		return assetKind{}, false
+	}
+	return ASSET_KINDS[idx], true
+}
+
+func asset_dir(dirs lutrisDirs, kind assetKind) string {
+	switch kind.Name {
+	case ASSET_COVER:
+		return dirs.CoverArtDirPath
+	case ASSET_BANNER:
+		return dirs.BannersDirPath
+	case ASSET_ICON:
+		return dirs.IconsDirPath
+	case ASSET_HERO:
+		return dirs.HeroesDirPath
+	case ASSET_LOGO:
+		return dirs.LogosDirPath
+	}
+	return ""
+}
+
+// asset_file_name follows Lutris' naming, icons being shared with the rest of
+// the desktop in the hicolor theme.
+func asset_file_name(kind assetKind, slug gameSlug, ext string) string {
+	if kind.Name == ASSET_ICON {
+		return "lutris_" + string(slug) + ext
+	}
+	return string(slug) + ext
+}
+
+func asset_missing(dirs lutrisDirs, kind assetKind, slug gameSlug) bool {
+	for _, ext := range kind.extensions() {
+		file := filepath.Join(asset_dir(dirs, kind), asset_file_name(kind, slug, ext))
+		if _, err := os.Stat(file); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// asset_needed also counts fallbacks, to replace them with real art.
+func asset_needed(dirs lutrisDirs, m *manifest, kind assetKind, slug gameSlug) bool {
+	return asset_missing(dirs, kind, slug) || m.is_fallback(slug, kind.Name)
+}
+
+// asset_files lists the files of the asset, one per format it exists in.
+func asset_files(dirs lutrisDirs, kind assetKind, slug gameSlug) []string {
+	var files []string
+	for _, ext := range kind.extensions() {
+		file := filepath.Join(asset_dir(dirs, kind), asset_file_name(kind, slug, ext))
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// remove_other_asset_files removes the files of the asset in formats other
+// than the one of keep, like a fallback replaced by art in another format.
+func remove_other_asset_files(dirs lutrisDirs, kind assetKind, slug gameSlug, keep string) {
+	for _, file := range asset_files(dirs, kind, slug) {
+		if file == keep {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			log.Warn("Error while removing replaced "+string(kind.Name), "file", file, "err", err)
+			continue
+		}
+		audit(AUDIT_DELETE, file, "replaced by "+keep)
+		if err := remove_sidecar(file); err != nil {
+			log.Warn("Error while removing replaced "+string(kind.Name), "file", file+PROVENANCE_SIDECAR_SUFFIX, "err", err)
+		}
+	}
+}
+
+func select_image(images []grid, kind assetKind) *grid {
+	for _, img := range images {
+		if kind.accepts(img) {
+			return &img
+		}
+	}
+	return nil
+}
+
+func images_for_kind(images []grid, kind assetKind) []grid {
+	var matching []grid
+	for _, img := range images {
+		if kind.accepts(img) {
+			matching = append(matching, img)
+		}
+	}
+	return matching
+}
+
+// diversify reorders images so the first ones come from different uploaders
+// and styles rather than being near-identical variants, keeping SteamGridDB's
+// order otherwise.
+func diversify(images []grid) []grid {
+	picked := make([]bool, len(images))
+	authors, styles := map[string]bool{}, map[string]bool{}
+	diverse := make([]grid, 0, len(images))
+	pick := func(accept func(author, style string) bool) {
+		for i, img := range images {
+			author := img.Author.Steam64
+			if author == "" {
+				author = img.Author.Name
+			}
+			if picked[i] || !accept(author, img.Style) {
+				continue
+			}
+			picked[i] = true
+			authors[author], styles[img.Style] = true, true
+			diverse = append(diverse, img)
+		}
+	}
+	pick(func(author, style string) bool { return !authors[author] && !styles[style] })
+	pick(func(author, style string) bool { return !authors[author] || !styles[style] })
+	pick(func(author, style string) bool { return true })
+	return diverse
+}
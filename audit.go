@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const AUDIT_FILE = "audit.jsonl"
+
+const AUDIT_WRITE = "write"
+const AUDIT_DELETE = "delete"
+const AUDIT_RENAME = "rename"
+const AUDIT_DB_UPDATE = "db-update"
+
+// auditCommand is the command running, recorded with every change it makes.
+var auditCommand string
+var auditMu sync.Mutex
+
+// auditEntry is one change made outside of the tool's own state: a file in
+// the Lutris directories or a row of the Lutris database.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Action  string    `json:"action"`
+	Path    string    `json:"path"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// audit appends to the audit log, which is only ever appended to. Failing to
+// record a change does not undo it, so errors are only logged.
+func audit(action, path, detail string) {
+	entry := auditEntry{Time: time.Now(), Command: auditCommand, Action: action, Path: path, Detail: detail}
+	if err := append_audit_entry(entry); err != nil {
+		log.Warn("Error while writing the audit log", "action", action, "path", path, "err", err)
+	}
+}
+
+func append_audit_entry(entry auditEntry) error {
+	dir, err := get_state_dir()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(dir, AUDIT_FILE), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func read_audit_entries() ([]auditEntry, error) {
+	dir, err := get_state_dir()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, AUDIT_FILE))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warn("Skipping malformed audit log line", "line", line, "err", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func run_log(args []string) {
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	command := fs.String("command", "", "only show changes made by this command, e.g. fetch")
+	since := fs.Duration("since", 0, "only show changes made in this last duration, e.g. 24h")
+	limit := fs.Int("limit", 0, "only show the last n changes")
+	parse_flags(fs, args)
+
+	entries, err := read_audit_entries()
+	if err != nil {
+		log.Fatal("An error occurred while reading the audit log", "err", err)
+	}
+	var shown []auditEntry
+	for _, e := range entries {
+		if *command != "" && e.Command != *command {
+			continue
+		}
+		if *since > 0 && time.Since(e.Time) > *since {
+			continue
+		}
+		shown = append(shown, e)
+	}
+	if *limit > 0 && len(shown) > *limit {
+		shown = shown[len(shown)-*limit:]
+	}
+	if len(shown) == 0 {
+		log.Info("No change recorded")
+		return
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "TIME\tCOMMAND\tACTION\tPATH\tDETAIL")
+	for _, e := range shown {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.Command, e.Action, e.Path, e.Detail)
+	}
+	out.Flush()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const BACKUP_DB_FILE = "backup-pga.db"
+
+// LUTRIS_BACKUP_DB_PATHS are where pga.db sits in a restored backup of the
+// Lutris data directory, of ~/.local or of the whole home directory.
+var LUTRIS_BACKUP_DB_PATHS = []string{
+	"pga.db",
+	filepath.Join("lutris", "pga.db"),
+	filepath.Join("share", "lutris", "pga.db"),
+	filepath.Join(".local", "share", "lutris", "pga.db"),
+}
+
+// librarySource reads the library from a Lutris backup and writes art below
+// a staging directory, laid out like a home directory, instead of using the
+// installed Lutris.
+type librarySource struct {
+	Backup    string
+	OutputDir string
+}
+
+func add_source_flags(fs *flag.FlagSet) *librarySource {
+	src := &librarySource{}
+	fs.StringVar(&src.Backup, "from-backup", "", "Lutris backup tarball, or directory of a restored backup, to read the library from")
+	fs.StringVar(&src.OutputDir, "output-dir", "", "write art below this directory, laid out like a home directory, instead of into Lutris")
+	return src
+}
+
+// backup_db copies the database out of the backup, which is never written to
+// even when it is a restored directory.
+func backup_db(source string) (string, error) {
+	cacheDir, err := get_cache_dir()
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(cacheDir, BACKUP_DB_FILE)
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return dest, extract_backup_db(source, dest)
+	}
+	for _, p := range LUTRIS_BACKUP_DB_PATHS {
+		f, err := os.Open(filepath.Join(source, p))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return dest, copy_to_file(f, dest)
+	}
+	return "", errors.New("no pga.db found in the backup directory")
+}
+
+// extract_backup_db reads tarballs, gzipped or not.
+func extract_backup_db(archive, dest string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errors.New("no pga.db found in the backup archive")
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Base(hdr.Name) == "pga.db" {
+			return copy_to_file(tr, dest)
+		}
+	}
+}
+
+// copy_to_file renames the file into place so concurrent readers never see it
+// half written.
+func copy_to_file(r io.Reader, dest string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const BUNDLE_FORMAT = "lutris-cover-art-fetcher/curation"
+const SIGNED_BUNDLE_FORMAT = "lutris-cover-art-fetcher/signed-curation"
+const BUNDLE_VERSION = 1
+const BUNDLE_SGDB_API = "v2"
+
+// curationBundle shares pins, overrides and blacklisted matches between
+// users.
+type curationBundle struct {
+	Format        string              `json:"format"`
+	Version       int                 `json:"version"`
+	Provenance    bundleProvenance    `json:"provenance"`
+	Compatibility bundleCompatibility `json:"compatibility"`
+
+	Pins      map[gameSlug]int                  `json:"pins"`
+	Overrides map[gameSlug]map[assetType]string `json:"overrides"`
+	Blacklist map[gameSlug][]int                `json:"blacklist"`
+}
+
+// signedBundle is what a signed bundle is written as: the bundle exactly as
+// it was encoded by the signer, and a detached signature over those bytes,
+// so fields a newer version adds are verified too rather than dropped.
+type signedBundle struct {
+	Format string `json:"format"`
+	// Payload is the encoded bundle, base64 in the file.
+	Payload   []byte          `json:"payload"`
+	Signature bundleSignature `json:"signature"`
+}
+
+type bundleProvenance struct {
+	Author      string    `json:"author,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// bundleCompatibility tells importers what the IDs and asset names refer to.
+type bundleCompatibility struct {
+	SgdbApi string      `json:"sgdb_api"`
+	Assets  []assetType `json:"assets"`
+}
+
+type bundleSignature struct {
+	PublicKey string `json:"public_key"`
+	Value     string `json:"value"`
+}
+
+func sign_bundle(payload []byte, key ed25519.PrivateKey) signedBundle {
+	return signedBundle{
+		Format:  SIGNED_BUNDLE_FORMAT,
+		Payload: payload,
+		Signature: bundleSignature{
+			PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+			Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+		},
+	}
+}
+
+// verify checks the signature and that it was made by one of the trusted
+// keys, given in base64.
+func (s *signedBundle) verify(trusted []string) error {
+	if !slices.Contains(trusted, s.Signature.PublicKey) {
+		return fmt.Errorf("bundle is signed by untrusted key %s", s.Signature.PublicKey)
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(s.Signature.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid signing key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(s.Signature.Value)
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+	if !ed25519.Verify(publicKey, s.Payload, sig) {
+		return errors.New("signature does not match the bundle content")
+	}
+	return nil
+}
+
+// decode_bundle reads a bundle file, signed or not, the signed bundle being
+// nil for the latter. The signature is left for the caller to verify.
+func decode_bundle(data []byte) (*curationBundle, *signedBundle, error) {
+	var header struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, nil, err
+	}
+	var signed *signedBundle
+	if header.Format == SIGNED_BUNDLE_FORMAT {
+		signed = &signedBundle{}
+		if err := json.Unmarshal(data, signed); err != nil {
+			return nil, nil, err
+		}
+		data = signed.Payload
+	}
+	var b curationBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, nil, err
+	}
+	return &b, signed, nil
+}
+
+// validate rejects bundles this version cannot make sense of.
+func (b *curationBundle) validate() error {
+	if b.Format != BUNDLE_FORMAT {
+		return fmt.Errorf("not a curation bundle, format is %q", b.Format)
+	}
+	if b.Version < 1 || b.Version > BUNDLE_VERSION {
+		return fmt.Errorf("unsupported bundle version %d, this version reads up to %d", b.Version, BUNDLE_VERSION)
+	}
+	if b.Compatibility.SgdbApi != BUNDLE_SGDB_API {
+		return fmt.Errorf("bundle refers to SteamGridDB API %q, expected %q", b.Compatibility.SgdbApi, BUNDLE_SGDB_API)
+	}
+	return nil
+}
+
+func run_export_curation(args []string) {
+	fs := flag.NewFlagSet("export-curation", flag.ExitOnError)
+	output := fs.String("o", "", "file to write the bundle to instead of stdout")
+	keyFile := fs.String("sign", "", "private key file, from curation-keygen, to sign the bundle with")
+	author := fs.String("author", "", "who made the bundle")
+	description := fs.String("description", "", "what the bundle covers")
+	parse_flags(fs, args)
+
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+	b := &curationBundle{
+		Format:        BUNDLE_FORMAT,
+		Version:       BUNDLE_VERSION,
+		Provenance:    bundleProvenance{Author: *author, Description: *description, CreatedAt: time.Now().UTC()},
+		Compatibility: bundleCompatibility{SgdbApi: BUNDLE_SGDB_API, Assets: []assetType{}},
+		Pins:          cur.Pins,
+		Overrides:     cur.Overrides,
+		Blacklist:     cur.Blacklist,
+	}
+	for _, assets := range cur.Overrides {
+		for asset := range assets {
+			if !slices.Contains(b.Compatibility.Assets, asset) {
+				b.Compatibility.Assets = append(b.Compatibility.Assets, asset)
+			}
+		}
+	}
+	slices.Sort(b.Compatibility.Assets)
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		log.Fatal("An error occurred while encoding the bundle", "err", err)
+	}
+	if *keyFile != "" {
+		key, err := read_signing_key(*keyFile)
+		if err != nil {
+			log.Fatal("An error occurred while reading the signing key", "err", err)
+		}
+		data, err = json.MarshalIndent(sign_bundle(data, key), "", "  ")
+		if err != nil {
+			log.Fatal("An error occurred while encoding the bundle", "err", err)
+		}
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		log.Fatal("An error occurred while writing the bundle", "err", err)
+	}
+	log.Info("Curation exported", "file", *output, "pins", len(b.Pins), "overrides", len(b.Overrides), "signed", *keyFile != "")
+}
+
+func run_import_curation(args []string) {
+	fs := flag.NewFlagSet("import-curation", flag.ExitOnError)
+	var trusted []string
+	fs.Func("trust", "base64 public key whose signed bundles are trusted, can be repeated", func(key string) error {
+		trusted = append(trusted, key)
+		return nil
+	})
+	allowUnsigned := fs.Bool("allow-unsigned", false, "import bundles that are not signed by a trusted key")
+	overwrite := fs.Bool("overwrite", false, "let the bundle replace your own pins and overrides")
+	parse_flags(fs, args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: import-curation [flags] <bundle.json>")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal("An error occurred while reading the bundle", "err", err)
+	}
+	b, signed, err := decode_bundle(data)
+	if err != nil {
+		log.Fatal("An error occurred while decoding the bundle", "err", err)
+	}
+	if err := b.validate(); err != nil {
+		log.Fatal("Invalid curation bundle", "err", err)
+	}
+	err = errors.New("bundle is not signed")
+	if signed != nil {
+		err = signed.verify(trusted)
+	}
+	if err != nil {
+		if !*allowUnsigned {
+			log.Fatal("Refusing to import an untrusted bundle, use --trust or --allow-unsigned", "err", err)
+		}
+		log.Warn("Importing an untrusted bundle", "err", err)
+	}
+	log.Info("Importing curation bundle", "author", b.Provenance.Author, "description", b.Provenance.Description, "created", b.Provenance.CreatedAt.Format(time.DateOnly))
+
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+	pins, overrides, kept := import_bundle(cur, b, *overwrite)
+	if err := cur.save(); err != nil {
+		log.Fatal("An error occurred while saving curation decisions", "err", err)
+	}
+	log.Info("Curation imported", "pins", pins, "overrides", overrides, "kept", kept)
+}
+
+// import_bundle skips entries that could not have been exported by this
+// tool, and keeps the user's own decisions unless overwrite is set. It
+// returns how many pins and overrides were imported and how many were kept.
+func import_bundle(cur *curation, b *curationBundle, overwrite bool) (pins, overrides, kept int) {
+	for slug, id := range b.Pins {
+		if !safe_slug(slug) || id <= 0 {
+			log.Warn("Skipping invalid pin", "game", slug, "id", id)
+			continue
+		}
+		if existing, ok := cur.pinned(slug); ok && existing != id && !overwrite {
+			kept++
+			continue
+		}
+		cur.pin(slug, id)
+		pins++
+	}
+	for slug, assets := range b.Overrides {
+		for asset, imageUrl := range assets {
+			if _, ok := asset_kind(asset); !ok || !safe_slug(slug) || !is_http_url(imageUrl) {
+				log.Warn("Skipping invalid override", "game", slug, "asset", asset, "url", imageUrl)
+				continue
+			}
+			if existing := cur.override_url(slug, asset); existing != "" && existing != imageUrl && !overwrite {
+				kept++
+				continue
+			}
+			cur.override(slug, asset, imageUrl)
+			overrides++
+		}
+	}
+	for slug, ids := range b.Blacklist {
+		if !safe_slug(slug) {
+			continue
+		}
+		for _, id := range ids {
+			cur.blacklist(slug, id)
+		}
+	}
+	return pins, overrides, kept
+}
+
+func is_http_url(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func run_curation_keygen(args []string) {
+	fs := flag.NewFlagSet("curation-keygen", flag.ExitOnError)
+	output := fs.String("o", "curation-key.pem", "file to write the private key to")
+	parse_flags(fs, args)
+
+	publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal("An error occurred while generating the key", "err", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		log.Fatal("An error occurred while encoding the key", "err", err)
+	}
+	// an empty file is the one --hardened creates to allow writing it, any
+	// other being a key never to overwrite
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Fatal("An error occurred while writing the key", "err", err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.Size() > 0 {
+		log.Fatal("An error occurred while writing the key", "err", fmt.Errorf("%s already exists", *output))
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		log.Fatal("An error occurred while writing the key", "err", err)
+	}
+	log.Info("Signing key written, share the public key with the people importing your bundles", "file", *output, "public key", base64.StdEncoding.EncodeToString(publicKey))
+}
+
+func read_signing_key(file string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an ed25519 key")
+	}
+	return edKey, nil
+}
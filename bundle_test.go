@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func signed_test_bundle(t *testing.T, payload []byte) ([]byte, string) {
+	t.Helper()
+	publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(sign_bundle(payload, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, base64.StdEncoding.EncodeToString(publicKey)
+}
+
+func TestSignedBundleVerifies(t *testing.T) {
+	// fields this version does not know and nil maps decoding as empty ones
+	// must not change what is verified
+	payload := []byte(`{"format":"lutris-cover-art-fetcher/curation","version":1,"compatibility":{"sgdb_api":"v2","assets":[]},"pins":{"celeste":2590},"overrides":null,"blacklist":{},"from_a_newer_version":true}`)
+	data, trusted := signed_test_bundle(t, payload)
+
+	b, signed, err := decode_bundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed == nil {
+		t.Fatal("signed bundle decoded as unsigned")
+	}
+	if !bytes.Equal(signed.Payload, payload) {
+		t.Errorf("payload = %s, want %s", signed.Payload, payload)
+	}
+	if err := signed.verify([]string{trusted}); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	if err := b.validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+	if b.Pins["celeste"] != 2590 {
+		t.Errorf("pins = %v, want celeste pinned to 2590", b.Pins)
+	}
+}
+
+func TestSignedBundleRejectsUntrustedKey(t *testing.T) {
+	data, _ := signed_test_bundle(t, []byte(`{"format":"lutris-cover-art-fetcher/curation"}`))
+	_, signed, err := decode_bundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := signed.verify([]string{base64.StdEncoding.EncodeToString(otherKey)}); err == nil {
+		t.Error("bundle signed by an untrusted key verified")
+	}
+}
+
+func TestSignedBundleRejectsTampering(t *testing.T) {
+	payload := []byte(`{"format":"lutris-cover-art-fetcher/curation","pins":{"celeste":2590}}`)
+	data, trusted := signed_test_bundle(t, payload)
+
+	var signed signedBundle
+	if err := json.Unmarshal(data, &signed); err != nil {
+		t.Fatal(err)
+	}
+	signed.Payload = bytes.Replace(signed.Payload, []byte("2590"), []byte("6666"), 1)
+	tampered, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, decoded, err := decode_bundle(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Pins["celeste"] != 6666 {
+		t.Fatalf("pins = %v, the payload was not tampered with", b.Pins)
+	}
+	if err := decoded.verify([]string{trusted}); err == nil {
+		t.Error("tampered bundle verified")
+	}
+}
+
+func TestUnsignedBundleDecodes(t *testing.T) {
+	b, signed, err := decode_bundle([]byte(`{"format":"lutris-cover-art-fetcher/curation","version":1,"pins":{"celeste":2590}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed != nil {
+		t.Error("unsigned bundle decoded as signed")
+	}
+	if b.Pins["celeste"] != 2590 {
+		t.Errorf("pins = %v, want celeste pinned to 2590", b.Pins)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const IMAGES_CACHE_DIR = "images"
+const IMAGES_CACHE_TTL = 24 * time.Hour
+
+// sgdbImages is shared by everything listing SteamGridDB images. It is keyed
+// by SteamGridDB game ID rather than slug, so duplicate library entries and
+// re-reviews of a game reuse one API response.
+var sgdbImages = &imageCache{entries: map[string]*imageCacheEntry{}}
+
+// imageCache keeps image lists in memory for the run and on disk for
+// IMAGES_CACHE_TTL. Concurrent lookups of the same list wait for a single
+// request.
+type imageCache struct {
+	mu      sync.Mutex
+	entries map[string]*imageCacheEntry
+	hits    int
+	misses  int
+}
+
+type imageCacheEntry struct {
+	ready chan struct{}
+	grids []grid
+	err   error
+}
+
+type cachedImages struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Grids     []grid    `json:"grids"`
+}
+
+func image_cache_key(endpoint string, gameId int, dimensions []string) string {
+	key := fmt.Sprintf("%s-%d", endpoint, gameId)
+	if len(dimensions) > 0 {
+		sorted := slices.Clone(dimensions)
+		slices.Sort(sorted)
+		key += "-" + strings.Join(sorted, "-")
+	}
+	return key
+}
+
+func (c *imageCache) get(endpoint string, gameId int, dimensions []string) ([]grid, error) {
+	key := image_cache_key(endpoint, gameId, dimensions)
+	c.mu.Lock()
+	e, found := c.entries[key]
+	if !found {
+		e = &imageCacheEntry{ready: make(chan struct{})}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+	if found {
+		<-e.ready
+		if e.err == nil {
+			c.count(true)
+		}
+		return e.grids, e.err
+	}
+
+	grids, ok := read_cached_images(key)
+	if ok {
+		c.count(true)
+	} else {
+		grids, e.err = query_steamgriddb_images(endpoint, gameId, dimensions)
+		if e.err == nil {
+			c.count(false)
+			write_cached_images(key, grids)
+		}
+	}
+	e.grids = grids
+	if e.err != nil {
+		// errors are not cached, the next lookup tries again
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.mu.Unlock()
+	}
+	close(e.ready)
+	return e.grids, e.err
+}
+
+func (c *imageCache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+func (c *imageCache) stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+func images_cache_file(key string) (string, error) {
+	dir, err := get_cache_dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, IMAGES_CACHE_DIR, key+".json"), nil
+}
+
+// read_cached_images treats an unreadable cache file like a missing one.
+func read_cached_images(key string) ([]grid, bool) {
+	file, err := images_cache_file(key)
+	if err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedImages
+	if err := json.Unmarshal(data, &cached); err != nil || time.Since(cached.FetchedAt) > IMAGES_CACHE_TTL {
+		return nil, false
+	}
+	return cached.Grids, true
+}
+
+// write_cached_images only logs errors, the images having been fetched
+// anyway.
+func write_cached_images(key string, grids []grid) {
+	if err := write_cached_images_file(key, grids); err != nil {
+		log.Warn("Error while caching images", "key", key, "err", err)
+	}
+}
+
+func write_cached_images_file(key string, grids []grid) error {
+	file, err := images_cache_file(key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cachedImages{FetchedAt: time.Now(), Grids: grids})
+	if err != nil {
+		return err
+	}
+	return write_cache_file(file, data)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const CACHE_SERVER_DEFAULT_ADDRESS = "localhost:8090"
+const CACHE_SERVER_API_DIR = "cache-server"
+const CACHE_SERVER_ASSETS_DIR = "assets"
+const CACHE_SERVER_API_ROUTE = "/api/v2/"
+const CACHE_SERVER_ASSETS_ROUTE = "/assets/"
+
+// SGDB_IMAGE_FIELDS are the fields of API objects holding image URLs, which
+// the cache server rewrites to point at itself.
+var SGDB_IMAGE_FIELDS = []string{"url", "thumb"}
+
+// run_cache_server shares one download of every API response and image
+// between the machines of a LAN, which point at it with
+// SGDB_API_URL=http://<host>:8090/api/v2/. Only the server needs a real API
+// key, clients can set SGDB_API_KEY to anything. It only listens on localhost
+// unless told otherwise, anyone reaching it using its key and quota.
+func run_cache_server(args []string) {
+	fs := flag.NewFlagSet("cache-server", flag.ExitOnError)
+	address := fs.String("listen", CACHE_SERVER_DEFAULT_ADDRESS, "address to listen on, use 0.0.0.0:8090 to share it with the LAN, and with it the API key and its quota")
+	publicUrl := fs.String("public-url", "", "URL clients reach the server at, which image URLs point to, e.g. http://nas.lan:8090 (default http:// and the --listen address)")
+	add_api_flag(fs)
+	parse_flags(fs, args)
+	baseUrl, err := cache_server_base_url(*address, *publicUrl)
+	if err != nil {
+		log.Fatal("Invalid --public-url value", "err", err)
+	}
+
+	SGDB_API_KEY = os.Getenv("SGDB_API_KEY")
+	if SGDB_API_KEY == "" {
+		log.Fatal("Please set the SGDB_API_KEY environment variable with your StreamGridDB API key")
+	}
+	cacheDir, err := get_cache_dir()
+	if err != nil {
+		log.Fatal("An error occurred while creating the cache directory", "err", err)
+	}
+	dir := filepath.Join(cacheDir, CACHE_SERVER_API_DIR)
+	s := &cacheServer{
+		dir:     dir,
+		baseUrl: baseUrl,
+		assets:  new_image_proxy(filepath.Join(dir, CACHE_SERVER_ASSETS_DIR), CACHE_SERVER_ASSETS_ROUTE),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+CACHE_SERVER_API_ROUTE+"{path...}", s.serve_api)
+	mux.HandleFunc("GET "+CACHE_SERVER_ASSETS_ROUTE+"{key}", s.assets.serve)
+
+	log.Info("Serving the SteamGridDB cache", "address", *address, "upstream", SGDB_API_URL)
+	if err := http.ListenAndServe(*address, mux); err != nil {
+		log.Fatal("An error occurred while serving the cache", "err", err)
+	}
+}
+
+type cacheServer struct {
+	dir string
+	// baseUrl is what image URLs are rewritten to start with, never the Host
+	// clients send, which would let them point the URLs anywhere.
+	baseUrl string
+	assets  *imageProxy
+}
+
+// cache_server_base_url is the public URL, else the listen address when it
+// names a host clients can reach.
+func cache_server_base_url(address, publicUrl string) (string, error) {
+	if publicUrl != "" {
+		u, err := url.Parse(publicUrl)
+		if err != nil {
+			return "", err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", fmt.Errorf("%q is not an http or https URL", publicUrl)
+		}
+		return strings.TrimSuffix(publicUrl, "/"), nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return "", fmt.Errorf("required when listening on all interfaces with %s", address)
+	}
+	return "http://" + address, nil
+}
+
+type cachedResponse struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// serve_api answers with the upstream response for the same path and query,
+// cached for IMAGES_CACHE_TTL, its image URLs rewritten to the server's
+// assets.
+func (s *cacheServer) serve_api(w http.ResponseWriter, r *http.Request) {
+	rawPath := strings.TrimPrefix(r.URL.EscapedPath(), CACHE_SERVER_API_ROUTE)
+	if r.URL.RawQuery != "" {
+		rawPath += "?" + r.URL.RawQuery
+	}
+	sum := sha256.Sum256([]byte(rawPath))
+	file := filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+
+	body, err := s.cached_api_response(r.Context(), file, rawPath)
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		http.Error(w, statusErr.Status, statusErr.Code)
+		return
+	}
+	if err != nil {
+		log.Warn("Error while proxying API request", "path", rawPath, "err", err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	body, err = rewrite_image_urls(body, func(u string) string {
+		return s.baseUrl + s.assets.register(u)
+	})
+	if err != nil {
+		log.Warn("Error while rewriting API response", "path", rawPath, "err", err)
+		http.Error(w, "invalid upstream response", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// cached_api_response keeps the upstream response as is, so the rewritten
+// URLs follow --public-url when it changes.
+func (s *cacheServer) cached_api_response(ctx context.Context, file, rawPath string) ([]byte, error) {
+	if data, err := os.ReadFile(file); err == nil {
+		var cached cachedResponse
+		if err := json.Unmarshal(data, &cached); err == nil && time.Since(cached.FetchedAt) <= IMAGES_CACHE_TTL {
+			return cached.Body, nil
+		}
+	}
+	body, err := http_get(ctx, strings.TrimSuffix(SGDB_API_URL, "/")+"/"+rawPath, true)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, errors.New("upstream response is not JSON")
+	}
+	data, err := json.Marshal(cachedResponse{FetchedAt: time.Now(), Body: body})
+	if err != nil {
+		return nil, err
+	}
+	if err := write_cache_file(file, data); err != nil {
+		log.Warn("Error while caching API response", "path", rawPath, "err", err)
+	}
+	return body, nil
+}
+
+// rewrite_image_urls replaces the image URLs of the objects listed in the
+// response data, leaving every other response untouched.
+func rewrite_image_urls(body []byte, rewrite func(string) string) ([]byte, error) {
+	var resp map[string]any
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	items, ok := resp["data"].([]any)
+	if !ok {
+		return body, nil
+	}
+	for _, item := range items {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, field := range SGDB_IMAGE_FIELDS {
+			if u, ok := obj[field].(string); ok && is_http_url(u) {
+				obj[field] = rewrite(u)
+			}
+		}
+	}
+	return json.Marshal(resp)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	"github.com/charmbracelet/log"
+)
+
+const PROVIDER_CATALOG = "catalog"
+
+const LUTRIS_MEDIA_URL = "https://lutris.net/games/"
+
+// toolCatalog maps the slugs of launchers and tools installed like games to
+// the URLs of their art by asset type. They are not games SteamGridDB knows
+// well, searching for them mostly matching games with similar names, so
+// their art comes from the catalog alone.
+type toolCatalog map[gameSlug]map[assetType]string
+
+// lutris_media is the art lutris.net has for one of the launchers it has
+// installers for.
+func lutris_media(slug gameSlug) map[assetType]string {
+	return map[assetType]string{
+		ASSET_COVER:  LUTRIS_MEDIA_URL + "coverart/" + string(slug) + ".jpg",
+		ASSET_BANNER: LUTRIS_MEDIA_URL + "banner/" + string(slug) + ".jpg",
+		ASSET_ICON:   LUTRIS_MEDIA_URL + "icon/" + string(slug) + ".png",
+	}
+}
+
+// BUILTIN_TOOL_CATALOG covers the launchers Lutris installs through wine,
+// under the slugs of their Lutris installers and the other names they are
+// commonly added under.
+var BUILTIN_TOOL_CATALOG = toolCatalog{
+	"battlenet":       lutris_media("battlenet"),
+	"battle-net":      lutris_media("battlenet"),
+	"ubisoft-connect": lutris_media("ubisoft-connect"),
+	"uplay":           lutris_media("ubisoft-connect"),
+	"ea-app":          lutris_media("ea-app"),
+	"itchio":          lutris_media("itchio"),
+	"itch":            lutris_media("itchio"),
+	"itch-io":         lutris_media("itchio"),
+}
+
+// tool_catalog is the built-in catalog extended with the tool_catalog
+// setting, whose entries replace the built-in ones of the same slug, e.g.
+// {"heroic": {"cover": "https://…", "icon": "https://…"}}. An empty entry
+// takes a slug out of the catalog, SteamGridDB being searched for it again.
+func tool_catalog() toolCatalog {
+	catalog := maps.Clone(BUILTIN_TOOL_CATALOG)
+	value := setting_value("tool_catalog")
+	if value == "" {
+		return catalog
+	}
+	var entries toolCatalog
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		log.Fatal("Invalid tool_catalog in the configuration", "err", err)
+	}
+	for slug, images := range entries {
+		if len(images) == 0 {
+			delete(catalog, slug)
+			continue
+		}
+		for asset, imageUrl := range images {
+			if _, ok := asset_kind(asset); !ok {
+				log.Fatal("Invalid tool_catalog in the configuration", "slug", slug, "err", fmt.Errorf("unknown asset type %q", asset))
+			}
+			if !is_http_url(imageUrl) {
+				log.Fatal("Invalid tool_catalog in the configuration", "slug", slug, "err", fmt.Errorf("%q is not an http or https URL", imageUrl))
+			}
+		}
+		catalog[slug] = images
+	}
+	return catalog
+}
+
+// images returns the art of the game when it is a tool of the catalog, nil
+// otherwise.
+func (c toolCatalog) images(slug gameSlug) (map[assetType]string, bool) {
+	images, ok := c[slug]
+	return images, ok
+}
+
+// fetch_catalog_art downloads the art the catalog has for a tool, the asset
+// types it has none for being skipped rather than searched for. Curation
+// overrides still win.
+func (r *fetchRun) fetch_catalog_art(g game, images map[assetType]string, kinds []assetKind) {
+	for _, kind := range kinds {
+		if r.cur.override_url(g.Slug, kind.Name) != "" {
+			r.fetch_asset(g, 0, kind, nil)
+			continue
+		}
+		imageUrl := images[kind.Name]
+		if imageUrl == "" {
+			log.Debug("Skipping "+string(kind.Name)+", the tool catalog has none", "game", g.Slug)
+			r.summary.count(kind, OUTCOME_SKIPPED)
+			continue
+		}
+		matching := &grid{Url: imageUrl, Mime: mime_type_from_url(imageUrl)}
+		r.download_asset(g, kind, matching, PROVIDER_CATALOG, r.manifest.is_fallback(g.Slug, kind.Name))
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const CHECKPOINT_FILE = "checkpoint.json"
+const DEFAULT_CHECKPOINT_EVERY = 100
+
+const (
+	// STAGE_MATCHED games have a SteamGridDB match, their art being fetched
+	// again on resume unless it is there already.
+	STAGE_MATCHED = "matched"
+	// STAGE_UNMATCHED games found no match, were quarantined or skipped, and
+	// are left out of a resumed run.
+	STAGE_UNMATCHED = "unmatched"
+)
+
+// checkpoint is the progress of a run, saved every few games so a huge
+// initial run that gets interrupted resumes without matching again the games
+// it went through. Games whose art got written need no checkpoint, the plan
+// of the resumed run leaving them out.
+type checkpoint struct {
+	mu sync.Mutex
+	// every is how many games are processed between two saves.
+	every     int
+	processed int
+
+	StartedAt time.Time                   `json:"started_at"`
+	SavedAt   time.Time                   `json:"saved_at"`
+	Games     map[gameSlug]checkpointGame `json:"games"`
+}
+
+type checkpointGame struct {
+	Stage  string `json:"stage"`
+	SgdbId int    `json:"sgdb_id,omitempty"`
+}
+
+// load_checkpoint starts a new checkpoint unless resume is set and a run was
+// interrupted.
+func load_checkpoint(resume bool, every int) *checkpoint {
+	c := &checkpoint{every: every, StartedAt: time.Now()}
+	var saved checkpoint
+	if err := read_state_file(CHECKPOINT_FILE, &saved); err != nil {
+		log.Warn("Error while reading the checkpoint, starting over", "err", err)
+	} else if saved.Games != nil && resume {
+		log.Info("Resuming the run interrupted at "+saved.SavedAt.Local().Format(time.DateTime), "games", len(saved.Games))
+		c.StartedAt, c.Games = saved.StartedAt, saved.Games
+	} else if saved.Games != nil {
+		log.Info("Starting over, run with --resume to continue the interrupted run instead")
+	}
+	if c.Games == nil {
+		c.Games = map[gameSlug]checkpointGame{}
+	}
+	return c
+}
+
+// skip leaves out of the plan the games a resumed run went through without a
+// match.
+func (c *checkpoint) skip(p assetPlan) assetPlan {
+	if c == nil {
+		return p
+	}
+	var items []planItem
+	for _, item := range p.Items {
+		if c.Games[item.Game.Slug].Stage == STAGE_UNMATCHED {
+			p.Skipped = append(p.Skipped, item)
+			continue
+		}
+		items = append(items, item)
+	}
+	p.Items = items
+	return p
+}
+
+func (c *checkpoint) matched(slug gameSlug) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := c.Games[slug]
+	return g.SgdbId, g.Stage == STAGE_MATCHED
+}
+
+func (c *checkpoint) record(slug gameSlug, stage string, sgdbId int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Games[slug] = checkpointGame{Stage: stage, SgdbId: sgdbId}
+}
+
+// done counts a processed game, telling when a save is due.
+func (c *checkpoint) done() bool {
+	if c == nil || c.every < 1 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.processed++
+	return c.processed%c.every == 0
+}
+
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.SavedAt = time.Now()
+	return write_state_file(CHECKPOINT_FILE, c)
+}
+
+// remove drops the checkpoint of a run that went through, forgetting the
+// games too so a run reused afterwards matches them again.
+func (c *checkpoint) remove() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.Games = map[gameSlug]checkpointGame{}
+	c.processed = 0
+	c.mu.Unlock()
+	remove_state_file(CHECKPOINT_FILE)
+}
+
+// save_checkpoint saves the checkpoint along with the quarantine, curation,
+// statistics and manifest, otherwise saved at the end of the run only, which
+// a resumed run needs too.
+func (r *fetchRun) save_checkpoint() {
+	if err := r.checkpoint.save(); err != nil {
+		log.Error("Error while saving the checkpoint", "err", err)
+		return
+	}
+	r.save_state()
+	log.Debug("Checkpoint saved")
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// run_clean removes the art of games that are no longer in the library,
+// archiving it first. Art named after a game of the library rather than
+// after its slug is renamed instead.
+func run_clean(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only list the files that would be renamed or removed")
+	add_lutris_version_flag(fs)
+	parse_flags(fs, args)
+
+	lutrisDirs, err := get_lutris_dir()
+	if err != nil {
+		log.Fatal("An error occurred while retrieving Lutris directories", "err", err)
+	}
+	db, err := connect_to_lutris_db(lutrisDirs.DbFilePath)
+	if err != nil {
+		log.Fatal("An error occurred while connecting to Lutris database", "err", err)
+	}
+	defer db.Close()
+	lutrisDirs = apply_lutris_compat(db, lutrisDirs, true)
+	// unlike fetching, never falls back to the cached game list, which could
+	// miss games added since and get their art removed
+	games, err := select_games(db)
+	if err != nil {
+		log.Fatal("An error occurred while fetching installed games", "err", err)
+	}
+	adopted, err := adopt_misnamed_assets(lutrisDirs, games, *dryRun)
+	if err != nil {
+		log.Fatal("An error occurred while renaming misnamed art", "err", err)
+	}
+	orphans, err := orphaned_assets(lutrisDirs, games)
+	if err != nil {
+		log.Fatal("An error occurred while looking for orphaned art", "err", err)
+	}
+	orphans = slices.DeleteFunc(orphans, func(file string) bool { return adopted[strings.TrimSuffix(file, PROVENANCE_SIDECAR_SUFFIX)] })
+	if len(orphans) == 0 {
+		log.Info("No orphaned art found")
+		return
+	}
+	if *dryRun {
+		for _, file := range orphans {
+			log.Info("Would remove", "file", file)
+		}
+		log.Info(fmt.Sprintf("%d files would be removed", len(orphans)))
+		return
+	}
+
+	// nothing is removed unless it could be archived
+	archive, err := archive_files("clean", orphans)
+	if err != nil {
+		log.Fatal("An error occurred while archiving the orphaned art, nothing was removed", "err", err)
+	}
+	removed := 0
+	for _, file := range orphans {
+		if err := os.Remove(file); err != nil {
+			log.Error("Error while removing orphaned art", "file", file, "err", err)
+			continue
+		}
+		audit(AUDIT_DELETE, file, "orphaned, archived in "+archive)
+		removed++
+	}
+	log.Info("Orphaned art removed", "files", removed, "archive", archive)
+}
+
+// orphaned_assets lists the art files, in the formats Lutris reads, whose
+// slug is no game of the library, along with their provenance sidecars.
+func orphaned_assets(dirs lutrisDirs, games []game) ([]string, error) {
+	slugs := map[gameSlug]bool{}
+	for _, g := range games {
+		slugs[g.Slug] = true
+	}
+	var orphans []string
+	for _, kind := range ASSET_KINDS {
+		dir := asset_dir(dirs, kind)
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			slug, ok := asset_slug(kind, e.Name())
+			if ok && e.Type().IsRegular() && !slugs[slug] {
+				file := filepath.Join(dir, e.Name())
+				orphans = append(orphans, file)
+				if _, err := os.Stat(file + PROVENANCE_SIDECAR_SUFFIX); err == nil {
+					orphans = append(orphans, file+PROVENANCE_SIDECAR_SUFFIX)
+				}
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// adopt_misnamed_assets gives the art files named after a game, e.g.
+// "Hades.png" copied by hand, the name Lutris reads them under, the slug
+// Lutris makes of either their name or the name of the game being theirs. A
+// file is only renamed when the game has no art of its type yet. It returns
+// the files renamed, or that would be with dryRun.
+func adopt_misnamed_assets(dirs lutrisDirs, games []game, dryRun bool) (map[string]bool, error) {
+	bySlug := map[gameSlug]game{}
+	for _, g := range games {
+		bySlug[g.Slug] = g
+	}
+	// names several games share are left out as ambiguous
+	byName := map[gameSlug]*game{}
+	for _, g := range games {
+		slug := lutris_slug(g.Name)
+		if _, ok := byName[slug]; ok {
+			byName[slug] = nil
+		} else {
+			byName[slug] = &g
+		}
+	}
+
+	adopted := map[string]bool{}
+	for _, kind := range ASSET_KINDS {
+		dir := asset_dir(dirs, kind)
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		claimed := map[gameSlug]bool{}
+		for _, e := range entries {
+			slug, ok := asset_slug(kind, e.Name())
+			if !ok || !e.Type().IsRegular() {
+				continue
+			}
+			if _, known := bySlug[slug]; known {
+				continue
+			}
+			g, ok := bySlug[lutris_slug(string(slug))]
+			if !ok {
+				named := byName[lutris_slug(string(slug))]
+				if named == nil {
+					continue
+				}
+				g = *named
+			}
+			if claimed[g.Slug] || !asset_missing(dirs, kind, g.Slug) {
+				continue
+			}
+			claimed[g.Slug] = true
+			file := filepath.Join(dir, e.Name())
+			dest := filepath.Join(dir, asset_file_name(kind, g.Slug, filepath.Ext(e.Name())))
+			if dryRun {
+				log.Info("Would rename", "file", file, "to", dest)
+				adopted[file] = true
+				continue
+			}
+			if err := os.Rename(file, dest); err != nil {
+				log.Error("Error while renaming misnamed art", "file", file, "err", err)
+				continue
+			}
+			if err := os.Rename(file+PROVENANCE_SIDECAR_SUFFIX, dest+PROVENANCE_SIDECAR_SUFFIX); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Warn("Error while renaming the provenance of misnamed art", "file", file, "err", err)
+			}
+			audit(AUDIT_RENAME, dest, "adopted from "+file)
+			log.Info("Misnamed art renamed", "game", g.Slug, "file", file, "to", dest)
+			adopted[file] = true
+		}
+	}
+	return adopted, nil
+}
+
+// asset_slug is the reverse of asset_file_name, false for the files Lutris
+// would not read for the kind.
+func asset_slug(kind assetKind, name string) (gameSlug, bool) {
+	ext := filepath.Ext(name)
+	if !slices.Contains(kind.extensions(), ext) {
+		return "", false
+	}
+	slug := strings.TrimSuffix(name, ext)
+	if kind.Name == ASSET_ICON {
+		var ok bool
+		if slug, ok = strings.CutPrefix(slug, "lutris_"); !ok {
+			return "", false
+		}
+	}
+	return gameSlug(slug), slug != ""
+}
+
+// run_refresh downloads the art of some or all games again, archiving the
+// files it replaces first.
+func run_refresh(args []string) {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	flags := add_fetch_flags(fs)
+	fs.BoolVar(&flags.opts.DryRun, "dry-run", false, "only report what would be matched and downloaded")
+	all := fs.Bool("all", false, "refresh every game of the library, or the ones matching --where")
+	parse_flags(fs, args)
+	if (*all || flags.where != "") == (fs.NArg() > 0) {
+		log.Fatal("Usage: refresh [flags] --all | --where <expression> | <slug>...")
+	}
+	if flag_set(fs, "only-missing") {
+		log.Fatal("Invalid --only-missing value", "err", "refresh replaces assets whether they are missing or not, use --assets")
+	}
+	opts := flags.options()
+	opts.Refresh = true
+
+	lutrisDirs, db := open_lutris(librarySource{})
+	defer db.Close()
+	games, err := load_library(db, librarySource{})
+	if err != nil {
+		log.Fatal("An error occurred while fetching installed games", "err", err)
+	}
+	if fs.NArg() > 0 {
+		games = select_slugs(games, to_slugs(fs.Args()))
+	}
+	games = opts.Where.filter(games)
+	if len(games) == 0 {
+		log.Info("No game to refresh")
+		return
+	}
+
+	var files []string
+	for _, g := range games {
+		for _, kind := range opts.assets_for(g) {
+			files = append(files, asset_files(lutrisDirs, kind, g.Slug)...)
+		}
+	}
+	if !opts.DryRun {
+		archive, err := archive_files("refresh", files)
+		if err != nil {
+			log.Fatal("An error occurred while archiving the art to replace, nothing was refreshed", "err", err)
+		}
+		if archive != "" {
+			log.Info("Current art archived", "files", len(files), "archive", archive)
+		}
+	}
+	log.Info(fmt.Sprintf("Refreshing %d games", len(games)))
+
+	run := new_fetch_run(opts, lutrisDirs)
+	run.process_plan(plan_assets(lutrisDirs, opts, run.manifest, games))
+	run.close_results()
+	run.commit_db_writes(db)
+	run.summary.print(opts.all_assets(), opts.DryRun)
+	run.profiler.stop()
+	if !opts.DryRun {
+		run.save()
+	}
+}
+
+// select_slugs keeps the games with the slugs, warning about the others.
+func select_slugs(games []game, slugs []gameSlug) []game {
+	var selected []game
+	for _, slug := range slugs {
+		idx := slices.IndexFunc(games, func(g game) bool { return g.Slug == slug })
+		if idx < 0 {
+			log.Warn("No game with this slug in the library", "game", slug)
+			continue
+		}
+		selected = append(selected, games[idx])
+	}
+	return selected
+}
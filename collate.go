@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+const GROUP_BY_RUNNER = "runner"
+const GROUP_BY_SERVICE = "service"
+const GROUP_BY_CATEGORY = "category"
+
+var GROUP_BY = []string{GROUP_BY_RUNNER, GROUP_BY_SERVICE, GROUP_BY_CATEGORY}
+
+// UNGROUPED is the group of the games without a runner, service or category.
+const UNGROUPED = "none"
+
+// listing_language is the locale listings are sorted for: the locale
+// setting, else the collation locale of the environment.
+func listing_language() language.Tag {
+	locale := setting_value("locale")
+	for _, env := range []string{"LC_ALL", "LC_COLLATE", "LANG"} {
+		if locale != "" {
+			break
+		}
+		locale = os.Getenv(env)
+	}
+	// POSIX locales look like fr_FR.UTF-8@euro
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	tag, err := language.Parse(strings.ReplaceAll(locale, "_", "-"))
+	if err != nil || locale == "C" || locale == "POSIX" {
+		return language.Und
+	}
+	return tag
+}
+
+// new_collator compares numbers by value, Game 2 coming before Game 10. A
+// collator is not safe for concurrent use.
+func new_collator() *collate.Collator {
+	return collate.New(listing_language(), collate.Numeric)
+}
+
+// sort_by_name sorts a listing by the name of its items in the order of the
+// user's language, falling back to their key for equal names.
+func sort_by_name[T any](items []T, name, key func(T) string) {
+	c := new_collator()
+	slices.SortStableFunc(items, func(a, b T) int {
+		if n := c.CompareString(name(a), name(b)); n != 0 {
+			return n
+		}
+		return strings.Compare(key(a), key(b))
+	})
+}
+
+func check_group_by(by string) error {
+	if by != "" && !slices.Contains(GROUP_BY, by) {
+		return fmt.Errorf("unknown grouping %q, expected one of %s", by, strings.Join(GROUP_BY, ", "))
+	}
+	return nil
+}
+
+// group_names are the groups a game is listed in, games being in as many
+// categories as the user put them in.
+func group_names(g game, by string) []string {
+	var names []string
+	switch by {
+	case GROUP_BY_RUNNER:
+		names = []string{string(g.Runner)}
+	case GROUP_BY_SERVICE:
+		names = []string{string(g.Service)}
+	case GROUP_BY_CATEGORY:
+		names = g.Categories
+	}
+	if len(names) == 0 || names[0] == "" {
+		return []string{UNGROUPED}
+	}
+	return names
+}
+
+type listingGroup[T any] struct {
+	Name  string
+	Items []T
+}
+
+// group_listing splits a sorted listing into groups sorted by name, the
+// games without any group last. Items keep their order within groups.
+func group_listing[T any](items []T, gameOf func(T) game, by string) []listingGroup[T] {
+	var groups []listingGroup[T]
+	for _, item := range items {
+		for _, name := range group_names(gameOf(item), by) {
+			idx := slices.IndexFunc(groups, func(g listingGroup[T]) bool { return g.Name == name })
+			if idx < 0 {
+				groups = append(groups, listingGroup[T]{Name: name})
+				idx = len(groups) - 1
+			}
+			groups[idx].Items = append(groups[idx].Items, item)
+		}
+	}
+	c := new_collator()
+	slices.SortFunc(groups, func(a, b listingGroup[T]) int {
+		if (a.Name == UNGROUPED) != (b.Name == UNGROUPED) {
+			if a.Name == UNGROUPED {
+				return 1
+			}
+			return -1
+		}
+		return c.CompareString(a.Name, b.Name)
+	})
+	return groups
+}
+
+// library_games returns the games of the cached game list by slug, for the
+// listings of state only knowing games by slug. Games it does not have yet
+// get their slug as name.
+func library_games() func(slug gameSlug) game {
+	var cached cachedLibrary
+	read_state_file(LIBRARY_FILE, &cached)
+	games := map[gameSlug]game{}
+	for _, g := range cached.Games {
+		games[g.Slug] = g
+	}
+	return func(slug gameSlug) game {
+		if g, ok := games[slug]; ok {
+			return g
+		}
+		return game{Slug: slug, Name: string(slug)}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// REQUIRE_ALL_ASSETS makes every asset type a game needs required.
+const REQUIRE_ALL_ASSETS = "all"
+
+// stagedAsset is an asset file written next to where Lutris reads it, moved
+// there once committed.
+type stagedAsset struct {
+	Kind   assetKind
+	File   string
+	Temp   string
+	Source string
+}
+
+func (s stagedAsset) commit() error {
+	defer timings.since(TIMING_DISK, time.Now())
+	if err := os.Rename(s.Temp, s.File); err != nil {
+		os.Remove(s.Temp)
+		return err
+	}
+	audit(AUDIT_WRITE, s.File, string(s.Kind.Name)+" from "+s.Source)
+	return nil
+}
+
+func (s stagedAsset) discard() {
+	if err := os.Remove(s.Temp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn("Error while removing staged "+string(s.Kind.Name), "file", s.Temp, "err", err)
+	}
+}
+
+// gameCommit holds the new art of a game with --required-assets until every
+// required asset type is there, so a flaky run never leaves a game with a new
+// cover and the banner of another match.
+type gameCommit struct {
+	game     game
+	required []assetKind
+	// pending counts the images of the game still in the CPU pool.
+	pending sync.WaitGroup
+
+	mu     sync.Mutex
+	staged []stagedAsset
+	// done records each staged asset once in place.
+	done []func(file string)
+}
+
+func parse_required_assets(list string) ([]assetKind, bool, error) {
+	if list == "" {
+		return nil, false, nil
+	}
+	if list == REQUIRE_ALL_ASSETS {
+		return nil, true, nil
+	}
+	kinds, err := parse_asset_kinds(list)
+	return kinds, false, err
+}
+
+// atomic tells whether the art of games is committed as a whole, dry runs
+// writing nothing to commit.
+func (o fetchOptions) atomic() bool {
+	return (o.RequireAll || len(o.RequiredAssets) > 0) && !o.DryRun
+}
+
+func (r *fetchRun) begin_commit(item planItem) *gameCommit {
+	c := &gameCommit{game: item.Game, required: item.Kinds}
+	if !r.opts.RequireAll {
+		c.required = only_kinds(item.Kinds, r.opts.RequiredAssets)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.commits == nil {
+		r.commits = map[gameSlug]*gameCommit{}
+	}
+	r.commits[item.Game.Slug] = c
+	return c
+}
+
+func (r *fetchRun) game_commit(g game) *gameCommit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.commits[g.Slug]
+}
+
+// write puts the asset in place and calls done with its file, or stages it
+// when the art of the game is committed as a whole, done being called on
+// commit.
+func (r *fetchRun) write(g game, kind assetKind, body []byte, mime, source string, proc imageProcessing, done func(file string)) error {
+	staged, err := stage_asset(r.dirs, kind, g.Slug, body, mime, source, proc)
+	if err != nil {
+		return err
+	}
+	if c := r.game_commit(g); c != nil {
+		c.mu.Lock()
+		c.staged = append(c.staged, staged)
+		c.done = append(c.done, done)
+		c.mu.Unlock()
+		return nil
+	}
+	if err := staged.commit(); err != nil {
+		return err
+	}
+	done(staged.File)
+	return nil
+}
+
+// finish_commit waits for the images of the game still being processed, then
+// moves them all into place when none of the required asset types is
+// missing, assets already there counting unless refreshed. Otherwise they are
+// discarded, as they are when the game is retried later.
+func (r *fetchRun) finish_commit(c *gameCommit, retrying bool) {
+	c.pending.Wait()
+	r.mu.Lock()
+	delete(r.commits, c.game.Slug)
+	r.mu.Unlock()
+
+	g := c.game
+	if retrying {
+		for _, s := range c.staged {
+			s.discard()
+		}
+		return
+	}
+	var missing []string
+	for _, kind := range c.required {
+		staged := slices.ContainsFunc(c.staged, func(s stagedAsset) bool { return s.Kind.Name == kind.Name })
+		if !staged && (r.opts.Refresh || asset_missing(r.dirs, kind, g.Slug)) {
+			missing = append(missing, string(kind.Name))
+		}
+	}
+	if len(missing) > 0 {
+		for _, s := range c.staged {
+			s.discard()
+			reason := fmt.Sprintf("%s held back, no %s to go with it", s.Kind.Name, strings.Join(missing, " nor "))
+			log.Warn("Not committing "+string(s.Kind.Name), "game", g.Slug, "code", E_HELD_BACK, "missing", strings.Join(missing, ","))
+			r.summary.add_skipped(g, E_HELD_BACK, reason)
+			r.summary.count(s.Kind, OUTCOME_SKIPPED)
+		}
+		return
+	}
+	for i, s := range c.staged {
+		if err := s.commit(); err != nil {
+			log.Error("Error while committing "+string(s.Kind.Name), "game", g.Slug, "code", error_code(err), "err", err)
+			r.summary.add_failed(g, error_code(err), err.Error())
+			r.summary.count(s.Kind, OUTCOME_FAILED)
+			continue
+		}
+		c.done[i](s.File)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// lutrisVersion is a Lutris release, compared part by part.
+type lutrisVersion [3]int
+
+func parse_lutris_version(s string) (lutrisVersion, error) {
+	var v lutrisVersion
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("%q is not a Lutris version, expected e.g. 0.5.13", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%q is not a Lutris version, expected e.g. 0.5.13", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func (v lutrisVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v lutrisVersion) compare(o lutrisVersion) int {
+	return slices.Compare(v[:], o[:])
+}
+
+// lutrisCompat lists the behaviors of Lutris that changed across releases,
+// from the release in Since on.
+type lutrisCompat struct {
+	Since lutrisVersion
+	// ArtInCache means banners and cover art are read from the cache
+	// directory, where Lutris kept them from 0.5.9 until 0.5.13 moved them
+	// back to its data directory.
+	ArtInCache bool
+	// CustomArtFlags means the games table has the has_custom_* columns
+	// --mark-custom sets.
+	CustomArtFlags bool
+}
+
+// LUTRIS_COMPAT is ordered by release, a version getting the last entry it
+// is not older than, so unreleased versions behave like the latest known one.
+var LUTRIS_COMPAT = []lutrisCompat{
+	{Since: lutrisVersion{0, 5, 0}},
+	{Since: lutrisVersion{0, 5, 9}, CustomArtFlags: true, ArtInCache: true},
+	{Since: lutrisVersion{0, 5, 13}, CustomArtFlags: true},
+}
+
+// SCHEMA_VERSIONS tells the oldest release a database can come from by the
+// columns of its games table, for when the package cannot be found. Current
+// releases have the same columns, so a version guessed from them is only
+// trusted for the custom art flags.
+var SCHEMA_VERSIONS = []struct {
+	Column string
+	Since  lutrisVersion
+}{
+	{"has_custom_coverart_big", lutrisVersion{0, 5, 9}},
+}
+
+// LUTRIS_PACKAGE_GLOBS are where distributions and Flatpak install the
+// Lutris python package, whose __init__.py has its version.
+var LUTRIS_PACKAGE_GLOBS = []string{
+	"/usr/lib/python3*/site-packages/lutris/__init__.py",
+	"/usr/lib/python3/dist-packages/lutris/__init__.py",
+	"/usr/lib64/python3*/site-packages/lutris/__init__.py",
+	"/usr/local/lib/python3*/*-packages/lutris/__init__.py",
+	"/var/lib/flatpak/app/net.lutris.Lutris/current/active/files/lib/python3*/site-packages/lutris/__init__.py",
+	"~/.local/share/flatpak/app/net.lutris.Lutris/current/active/files/lib/python3*/site-packages/lutris/__init__.py",
+}
+
+var PACKAGE_VERSION = regexp.MustCompile(`(?m)^__version__\s*=\s*["']([^"']+)["']`)
+
+// lutrisVersionOverride is set by LUTRIS_VERSION or --lutris-version, for
+// unreleased versions or installs the detection gets wrong.
+var lutrisVersionOverride *lutrisVersion
+
+// add_lutris_version_flag is added to every command reading Lutris'
+// directories or database.
+func add_lutris_version_flag(fs *flag.FlagSet) {
+	fs.Func("lutris-version", "Lutris version to behave as, instead of the detected one (default $LUTRIS_VERSION)", set_lutris_version)
+}
+
+func set_lutris_version(s string) error {
+	v, err := parse_lutris_version(s)
+	if err != nil {
+		return err
+	}
+	lutrisVersionOverride = &v
+	return nil
+}
+
+// detect_lutris_version returns the overridden version, the version of the
+// installed package or the oldest version the database schema allows, where
+// it got it from, and whether it is the actual version rather than a guess.
+func detect_lutris_version(db *sql.DB) (lutrisVersion, string, bool) {
+	if lutrisVersionOverride != nil {
+		return *lutrisVersionOverride, "override", true
+	}
+	if v, file, ok := lutris_package_version(); ok {
+		return v, file, true
+	}
+	v := LUTRIS_COMPAT[0].Since
+	columns, err := games_columns(db)
+	if err != nil {
+		log.Debug("Error while reading the Lutris database schema", "err", err)
+		return v, "default", false
+	}
+	for _, marker := range SCHEMA_VERSIONS {
+		if slices.Contains(columns, marker.Column) && marker.Since.compare(v) > 0 {
+			v = marker.Since
+		}
+	}
+	return v, "database schema", false
+}
+
+func lutris_package_version() (lutrisVersion, string, bool) {
+	homeDir, _ := os.UserHomeDir()
+	for _, pattern := range LUTRIS_PACKAGE_GLOBS {
+		if rest, ok := strings.CutPrefix(pattern, "~/"); ok {
+			if homeDir == "" {
+				continue
+			}
+			pattern = filepath.Join(homeDir, rest)
+		}
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			match := PACKAGE_VERSION.FindSubmatch(data)
+			if match == nil {
+				continue
+			}
+			if v, err := parse_lutris_version(string(match[1])); err == nil {
+				return v, file, true
+			}
+		}
+	}
+	return lutrisVersion{}, "", false
+}
+
+func games_columns(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('games')")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+func lutris_compat(v lutrisVersion) lutrisCompat {
+	compat := LUTRIS_COMPAT[0]
+	for _, c := range LUTRIS_COMPAT {
+		if v.compare(c.Since) >= 0 {
+			compat = c
+		}
+	}
+	return compat
+}
+
+// apply_lutris_compat detects the Lutris version and moves the directories
+// to where it reads art from. Directories below --output-dir keep the layout
+// they are documented with, and so does the data directory of the oldest and
+// current releases when the version is only guessed.
+func apply_lutris_compat(db *sql.DB, dirs lutrisDirs, relocate bool) lutrisDirs {
+	v, from, known := detect_lutris_version(db)
+	dirs.Compat = lutris_compat(v)
+	log.Debug("Lutris version", "version", v, "from", from, "compat", dirs.Compat.Since)
+	if relocate && known && dirs.Compat.ArtInCache {
+		cacheDir, err := lutris_cache_dir()
+		if err != nil {
+			log.Fatal("An error occurred while retrieving Lutris directories", "err", err)
+		}
+		dirs.BannersDirPath = filepath.Join(cacheDir, "banners")
+		dirs.CoverArtDirPath = filepath.Join(cacheDir, "coverart")
+	}
+	return dirs
+}
+
+func lutris_cache_dir() (string, error) {
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if cacheHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		cacheHome = filepath.Join(homeDir, ".cache")
+	}
+	return filepath.Join(cacheHome, "lutris"), nil
+}
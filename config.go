@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const CONFIG_FILE = "config.json"
+const CONFIG_PROFILES_KEY = "profiles"
+
+// configFile is the user's configuration, unlike the state it is only ever
+// written by hand. Its keys are the names of SETTINGS, and its profiles are
+// named sets of them taking precedence over the rest of the file, e.g.
+// {"workers": 8, "profiles": {"lan": {"api_url": "http://nas:8090/api/v2/"}}}.
+type configFile struct {
+	Path     string
+	Values   map[string]json.RawMessage
+	Profiles map[string]map[string]json.RawMessage
+}
+
+func get_config_dir() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configHome = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configHome, STATE_DIR_NAME), nil
+}
+
+// load_config returns an empty configuration when there is no config file.
+func load_config() (configFile, error) {
+	cfg := configFile{Values: map[string]json.RawMessage{}, Profiles: map[string]map[string]json.RawMessage{}}
+	dir, err := get_config_dir()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Path = filepath.Join(dir, CONFIG_FILE)
+	data, err := os.ReadFile(cfg.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg.Values); err != nil {
+		return cfg, err
+	}
+	if profiles, ok := cfg.Values[CONFIG_PROFILES_KEY]; ok {
+		delete(cfg.Values, CONFIG_PROFILES_KEY)
+		if err := json.Unmarshal(profiles, &cfg.Profiles); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
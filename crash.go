@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const CRASHES_DIR = "crashes"
+const CRASHES_KEPT = 10
+const CRASH_LOG_LINES = 200
+
+var ANSI_ESCAPE = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+
+// CRASH_IGNORED_FATALS are the fatal errors caused by the command line or the
+// environment, which the message explains better than a crash report.
+var CRASH_IGNORED_FATALS = []string{"FATA Usage:", "FATA Invalid ", "FATA Please set "}
+
+// logTail keeps the last lines logged for crash reports, writing them
+// through to out. It writes a crash report as soon as a fatal error is
+// logged, log.Fatal exiting right after.
+type logTail struct {
+	mu    sync.Mutex
+	out   io.Writer
+	lines []string
+}
+
+var crashLog = &logTail{out: os.Stderr}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	text := strings.TrimRight(ANSI_ESCAPE.ReplaceAllString(string(p), ""), "\n")
+	t.mu.Lock()
+	t.lines = append(t.lines, strings.Split(text, "\n")...)
+	if len(t.lines) > CRASH_LOG_LINES {
+		t.lines = slices.Clone(t.lines[len(t.lines)-CRASH_LOG_LINES:])
+	}
+	t.mu.Unlock()
+	n, err := t.out.Write(p)
+	fatal := strings.HasPrefix(text, "FATA ")
+	if fatal && !slices.ContainsFunc(CRASH_IGNORED_FATALS, func(prefix string) bool { return strings.HasPrefix(text, prefix) }) {
+		write_crash_report("fatal error", debug.Stack())
+	}
+	return n, err
+}
+
+func (t *logTail) tail() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.lines)
+}
+
+// report_panic writes a crash report before letting the panic go on. It is
+// deferred by main and the worker goroutines, a panic in any goroutine
+// ending the process.
+func report_panic() {
+	if v := recover(); v != nil {
+		write_crash_report(fmt.Sprintf("panic: %v", v), debug.Stack())
+		panic(v)
+	}
+}
+
+// write_crash_report saves what a bug report needs in the state directory
+// and prints where. Being called while logging a fatal error, it must not log
+// anything itself.
+func write_crash_report(reason string, stack []byte) {
+	stateDir, err := get_state_dir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write a crash report:", err)
+		return
+	}
+	dir := filepath.Join(stateDir, CRASHES_DIR)
+	file := filepath.Join(dir, "crash-"+time.Now().Format("20060102-150405")+".txt")
+	err = os.MkdirAll(dir, 0o755)
+	if err == nil {
+		err = os.WriteFile(file, crash_report(reason, stack), 0o600)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write a crash report:", err)
+		return
+	}
+	prune_crash_reports(dir)
+	fmt.Fprintf(os.Stderr, "A crash report was written to %s, please attach it to your bug report\n", file)
+}
+
+func crash_report(reason string, stack []byte) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "lutris-cover-art-fetcher crash report\n\n")
+	fmt.Fprintf(&b, "Time: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Reason: %s\n", reason)
+	fmt.Fprintf(&b, "Command: %s\n", strings.Join(os.Args[1:], " "))
+
+	fmt.Fprintf(&b, "\n## Versions\n\n")
+	version, revision := "unknown", ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				revision = " (" + s.Value + ")"
+			}
+		}
+	}
+	fmt.Fprintf(&b, "Tool: %s%s\n", version, revision)
+	fmt.Fprintf(&b, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	switch v, source, ok := lutris_package_version(); {
+	case lutrisVersionOverride != nil:
+		fmt.Fprintf(&b, "Lutris: %s (overridden)\n", lutrisVersionOverride)
+	case ok:
+		fmt.Fprintf(&b, "Lutris: %s (%s)\n", v, source)
+	default:
+		fmt.Fprintf(&b, "Lutris: not found\n")
+	}
+
+	// settings are only resolved once the flags are parsed
+	if effectiveSettings != nil {
+		fmt.Fprintf(&b, "\n## Settings\n\n")
+		for _, s := range SETTINGS {
+			resolved := effectiveSettings[s.Name]
+			fmt.Fprintf(&b, "%s = %s (%s)\n", s.Name, s.display(resolved.Value), resolved.Source)
+		}
+	}
+
+	fmt.Fprintf(&b, "\n## Log\n\n%s\n", strings.Join(crashLog.tail(), "\n"))
+	fmt.Fprintf(&b, "\n## Stack\n\n%s", stack)
+	return []byte(b.String())
+}
+
+// prune_crash_reports keeps the newest reports, their names sorting by date.
+func prune_crash_reports(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var reports []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasPrefix(e.Name(), "crash-") {
+			reports = append(reports, e.Name())
+		}
+	}
+	slices.Sort(reports)
+	for len(reports) > CRASHES_KEPT {
+		os.Remove(filepath.Join(dir, reports[0]))
+		reports = reports[1:]
+	}
+}
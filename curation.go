@@ -0,0 +1,115 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const CURATION_FILE = "curation.json"
+
+type curation struct {
+	mu sync.Mutex
+
+	// Pins force a slug to a SteamGridDB game ID, skipping the search.
+	Pins map[gameSlug]int `json:"pins"`
+	// Overrides force the image URL used for an asset of a slug, e.g. "cover".
+	Overrides map[gameSlug]map[assetType]string `json:"overrides"`
+	// Blacklist holds SteamGridDB game IDs that must never match a slug.
+	Blacklist map[gameSlug][]int `json:"blacklist"`
+	// Notes are free text about the decisions taken for a slug, for the user
+	// only, so they are left out of exported bundles.
+	Notes map[gameSlug][]curationNote `json:"notes,omitempty"`
+}
+
+type curationNote struct {
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func load_curation() (*curation, error) {
+	cur := &curation{}
+	err := read_state_file(CURATION_FILE, cur)
+	if cur.Pins == nil {
+		cur.Pins = map[gameSlug]int{}
+	}
+	if cur.Overrides == nil {
+		cur.Overrides = map[gameSlug]map[assetType]string{}
+	}
+	if cur.Blacklist == nil {
+		cur.Blacklist = map[gameSlug][]int{}
+	}
+	if cur.Notes == nil {
+		cur.Notes = map[gameSlug][]curationNote{}
+	}
+	return cur, err
+}
+
+func (c *curation) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return write_state_file(CURATION_FILE, c)
+}
+
+func (c *curation) pinned(slug gameSlug) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.Pins[slug]
+	return id, ok
+}
+
+func (c *curation) pin(slug gameSlug, gameId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Pins[slug] = gameId
+}
+
+func (c *curation) override_url(slug gameSlug, asset assetType) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Overrides[slug][asset]
+}
+
+func (c *curation) override(slug gameSlug, asset assetType, url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Overrides[slug] == nil {
+		c.Overrides[slug] = map[assetType]string{}
+	}
+	c.Overrides[slug][asset] = url
+}
+
+func (c *curation) blacklist(slug gameSlug, gameId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.Blacklist[slug], gameId) {
+		c.Blacklist[slug] = append(c.Blacklist[slug], gameId)
+	}
+}
+
+func (c *curation) is_blacklisted(slug gameSlug, gameId int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.Blacklist[slug], gameId)
+}
+
+func (c *curation) add_note(slug gameSlug, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Notes[slug] = append(c.Notes[slug], curationNote{Text: text, CreatedAt: time.Now()})
+}
+
+func (c *curation) notes(slug gameSlug) []curationNote {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.Notes[slug])
+}
+
+// clear_notes returns how many notes were removed.
+func (c *curation) clear_notes(slug gameSlug) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.Notes[slug])
+	delete(c.Notes, slug)
+	return n
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// dbWrites collects the updates to the Lutris database made during a run, to
+// apply them in one transaction at its end: either every game gets its flags
+// or, on any failure, none does. A nil dbWrites makes no writes.
+type dbWrites struct {
+	mu      sync.Mutex
+	columns map[int64][]string
+	slugs   map[int64]gameSlug
+}
+
+func new_db_writes() *dbWrites {
+	return &dbWrites{columns: map[int64][]string{}, slugs: map[int64]gameSlug{}}
+}
+
+// mark_custom records that the game now has a custom image of the kind, for
+// the kinds Lutris keeps a flag for.
+func (w *dbWrites) mark_custom(g game, kind assetKind) {
+	if w == nil || kind.DbFlag == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !slices.Contains(w.columns[g.Id], kind.DbFlag) {
+		w.columns[g.Id] = append(w.columns[g.Id], kind.DbFlag)
+	}
+	w.slugs[g.Id] = g.Slug
+}
+
+// commit leaves the pending updates in place when it fails, so a later
+// commit can try again.
+func (w *dbWrites) commit(db *sql.DB, dbPath string) (int, error) {
+	if w == nil {
+		return 0, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.columns) == 0 {
+		return 0, nil
+	}
+	ids := make([]int64, 0, len(w.columns))
+	for id := range w.columns {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		// the columns come from ASSET_KINDS, never from the user
+		var set []string
+		for _, column := range w.columns[id] {
+			set = append(set, column+" = 1")
+		}
+		if _, err := tx.Exec("UPDATE games SET "+strings.Join(set, ", ")+" WHERE id = ?", id); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("updating %s: %w", w.slugs[id], err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for _, id := range ids {
+		audit(AUDIT_DB_UPDATE, dbPath, string(w.slugs[id])+": "+strings.Join(w.columns[id], ", "))
+	}
+	w.columns = map[int64][]string{}
+	w.slugs = map[int64]gameSlug{}
+	return len(ids), nil
+}
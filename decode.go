@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const DEBUG_DIR = "debug"
+const BODY_SAMPLE_LENGTH = 200
+
+// debugDir receives the malformed API responses when --debug is set.
+var debugDir string
+var debugPayloads atomic.Int64
+
+// sgdbEnvelope wraps every SteamGridDB API response.
+type sgdbEnvelope struct {
+	Success *bool           `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Errors  []string        `json:"errors"`
+}
+
+// malformedResponseError is returned for responses that are not the JSON the
+// API documents, such as an HTML page from a captive portal or a proxy. The
+// game is worth retrying, the request itself having succeeded.
+type malformedResponseError struct {
+	Url    string
+	Status int
+	Sample string
+	Err    error
+}
+
+func (e *malformedResponseError) Error() string {
+	return fmt.Sprintf("malformed API response (status %d): %v, body starts with %q", e.Status, e.Err, e.Sample)
+}
+
+func (e *malformedResponseError) Unwrap() error {
+	return e.Err
+}
+
+func is_malformed_response(err error) bool {
+	var malformed *malformedResponseError
+	return errors.As(err, &malformed)
+}
+
+// sgdb_get_json decodes the data of the response into v.
+func sgdb_get_json(ctx context.Context, rawUrl string, v any) error {
+	data, status, err := sgdb_get_data(ctx, rawUrl)
+	if err != nil || data == nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return malformed_response(rawUrl, status, data, err)
+	}
+	return nil
+}
+
+// sgdb_get_list skips the items it cannot decode rather than the whole list,
+// a single odd image or game being no reason to fail the game.
+func sgdb_get_list[T any](ctx context.Context, rawUrl string) ([]T, error) {
+	data, status, err := sgdb_get_data(ctx, rawUrl)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, malformed_response(rawUrl, status, data, err)
+	}
+	items := make([]T, 0, len(raws))
+	var skipped int
+	for _, raw := range raws {
+		var item T
+		if err := json.Unmarshal(raw, &item); err != nil {
+			if skipped == 0 {
+				log.Warn("Skipping malformed items of API response", "url", rawUrl, "err", err, "item", body_sample(raw))
+				capture_payload(rawUrl, status, data)
+			}
+			skipped++
+			continue
+		}
+		items = append(items, item)
+	}
+	if skipped > 0 {
+		log.Debug("Malformed items skipped", "url", rawUrl, "skipped", skipped, "kept", len(items))
+	}
+	return items, nil
+}
+
+// sgdb_get_data returns nil data when the API answered with none.
+func sgdb_get_data(ctx context.Context, rawUrl string) (json.RawMessage, int, error) {
+	body, status, err := http_get_with_status(ctx, rawUrl, true)
+	if err != nil {
+		return nil, status, err
+	}
+	var env sgdbEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, status, malformed_response(rawUrl, status, body, err)
+	}
+	if env.Success != nil && !*env.Success {
+		return nil, status, malformed_response(rawUrl, status, body, fmt.Errorf("API reported a failure: %s", strings.Join(env.Errors, ", ")))
+	}
+	if len(env.Data) == 0 || string(env.Data) == "null" {
+		return nil, status, nil
+	}
+	return env.Data, status, nil
+}
+
+func malformed_response(rawUrl string, status int, body []byte, err error) error {
+	capture_payload(rawUrl, status, body)
+	return &malformedResponseError{Url: rawUrl, Status: status, Sample: body_sample(body), Err: err}
+}
+
+func body_sample(body []byte) string {
+	sample := string(body)
+	if len(sample) > BODY_SAMPLE_LENGTH {
+		sample = sample[:BODY_SAMPLE_LENGTH] + "…"
+	}
+	return strings.ToValidUTF8(sample, "�")
+}
+
+type debugPayload struct {
+	Url        string    `json:"url"`
+	Status     int       `json:"status,omitempty"`
+	CapturedAt time.Time `json:"captured_at"`
+	Body       string    `json:"body"`
+}
+
+// capture_payload only logs errors, the capture being a diagnostic.
+func capture_payload(rawUrl string, status int, body []byte) {
+	if debugDir == "" {
+		return
+	}
+	now := time.Now()
+	file := filepath.Join(debugDir, fmt.Sprintf("%s-%d.json", now.Format("20060102-150405"), debugPayloads.Add(1)))
+	data, err := json.MarshalIndent(debugPayload{Url: rawUrl, Status: status, CapturedAt: now, Body: string(body)}, "", "  ")
+	if err == nil {
+		err = write_cache_file(file, data)
+	}
+	if err != nil {
+		log.Warn("Error while capturing API response", "url", rawUrl, "err", err)
+		return
+	}
+	log.Debug("API response captured", "url", rawUrl, "file", file)
+}
+
+// enable_debug logs debug messages and captures malformed API responses in
+// the cache directory.
+func enable_debug() error {
+	log.SetLevel(log.DebugLevel)
+	dir, err := get_cache_dir()
+	if err != nil {
+		return err
+	}
+	debugDir = filepath.Join(dir, DEBUG_DIR)
+	return nil
+}
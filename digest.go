@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const HISTORY_FILE = "history.json"
+
+// HISTORY_KEPT is how long runs stay in the history, enough for a digest to
+// tell failures that persist from one-off ones.
+const HISTORY_KEPT = 8 * 7 * 24 * time.Hour
+
+const DIGEST_FORMAT_MARKDOWN = "markdown"
+const DIGEST_FORMAT_HTML = "html"
+
+// DIGEST_PERSISTENT_FAILURES is how many runs a game has to fail in, without
+// getting art since, to be reported as persistently failing.
+const DIGEST_PERSISTENT_FAILURES = 2
+
+// runHistory is what every run that wrote something got and failed to get,
+// for the digest.
+type runHistory struct {
+	Runs []runRecord `json:"runs"`
+}
+
+type runRecord struct {
+	Time      time.Time      `json:"time"`
+	Command   string         `json:"command"`
+	Fetched   []fetchedEntry `json:"fetched,omitempty"`
+	Failed    []summaryEntry `json:"failed,omitempty"`
+	Unmatched []summaryEntry `json:"unmatched,omitempty"`
+	Ambiguous []summaryEntry `json:"ambiguous,omitempty"`
+}
+
+// record_run adds the run to the history, dropping the runs too old to be in
+// any digest. Failing to record a run is only logged.
+func record_run(s *runSummary) {
+	s.mu.Lock()
+	record := runRecord{
+		Time:      time.Now(),
+		Command:   auditCommand,
+		Fetched:   slices.Clone(s.Fetched),
+		Failed:    slices.Clone(s.Failed),
+		Unmatched: slices.Clone(s.Unmatched),
+		Ambiguous: slices.Clone(s.Ambiguous),
+	}
+	s.mu.Unlock()
+
+	var h runHistory
+	if err := read_state_file(HISTORY_FILE, &h); err != nil {
+		log.Warn("Error while reading the run history, starting it over", "err", err)
+	}
+	h.Runs = slices.DeleteFunc(h.Runs, func(r runRecord) bool { return time.Since(r.Time) > HISTORY_KEPT })
+	h.Runs = append(h.Runs, record)
+	if err := write_state_file(HISTORY_FILE, h); err != nil {
+		log.Warn("Error while saving the run history", "err", err)
+	}
+}
+
+// digest is what happened to the library over a period, as rendered by the
+// digest templates.
+type digest struct {
+	Since    time.Time
+	Until    time.Time
+	Runs     int
+	Fetched  []listingGroup[digestGame]
+	Games    int
+	Assets   int
+	Upgrades []digestUpgrade
+	Failures []digestFailure
+}
+
+type digestGame struct {
+	Game   game
+	Assets []assetType
+	Notes  []string
+}
+
+// digestUpgrade is a game with better art to get: real art for its fallbacks,
+// or a match to pick in review.
+type digestUpgrade struct {
+	Slug   gameSlug
+	Reason string
+	Notes  []string
+}
+
+type digestFailure struct {
+	Slug     gameSlug
+	Name     string
+	Runs     int
+	LastSeen time.Time
+	Code     string
+	Reason   string
+	Notes    []string
+}
+
+// build_digest groups the new art by groupBy when set. The notes of the games
+// come along, telling why their art is what it is.
+func build_digest(h runHistory, m *manifest, q *quarantine, cur *curation, since time.Time, groupBy string) digest {
+	d := digest{Since: since, Until: time.Now()}
+	notes := func(slug gameSlug) []string {
+		var texts []string
+		for _, n := range cur.notes(slug) {
+			texts = append(texts, n.Text)
+		}
+		return texts
+	}
+	fetched := map[gameSlug]*digestGame{}
+	gameOf := library_games()
+	type failures struct {
+		entry    summaryEntry
+		runs     int
+		lastSeen time.Time
+	}
+	failing := map[gameSlug]*failures{}
+	lastFetched := map[gameSlug]time.Time{}
+	for _, run := range h.Runs {
+		for _, e := range run.Fetched {
+			lastFetched[e.Slug] = run.Time
+		}
+		if run.Time.Before(since) {
+			continue
+		}
+		d.Runs++
+		for _, e := range run.Fetched {
+			g := fetched[e.Slug]
+			if g == nil {
+				g = &digestGame{Game: gameOf(e.Slug), Notes: notes(e.Slug)}
+				g.Game.Name = e.Name
+				fetched[e.Slug] = g
+			}
+			if !slices.Contains(g.Assets, e.Asset) {
+				g.Assets = append(g.Assets, e.Asset)
+			}
+			d.Assets++
+		}
+		// a game failing for several of its assets in a run fails once
+		seen := map[gameSlug]bool{}
+		for _, e := range slices.Concat(run.Failed, run.Unmatched) {
+			if seen[e.Slug] {
+				continue
+			}
+			seen[e.Slug] = true
+			f := failing[e.Slug]
+			if f == nil {
+				f = &failures{}
+				failing[e.Slug] = f
+			}
+			f.entry, f.runs, f.lastSeen = e, f.runs+1, run.Time
+		}
+	}
+	var games []digestGame
+	for _, g := range fetched {
+		games = append(games, *g)
+	}
+	sort_by_name(games, func(g digestGame) string { return g.Game.Name }, func(g digestGame) string { return string(g.Game.Slug) })
+	d.Games = len(games)
+	if groupBy == "" {
+		d.Fetched = []listingGroup[digestGame]{{Items: games}}
+	} else {
+		d.Fetched = group_listing(games, func(g digestGame) game { return g.Game }, groupBy)
+	}
+
+	for slug, f := range failing {
+		if f.runs < DIGEST_PERSISTENT_FAILURES || lastFetched[slug].After(f.lastSeen) {
+			continue
+		}
+		d.Failures = append(d.Failures, digestFailure{slug, f.entry.Name, f.runs, f.lastSeen, f.entry.Code, f.entry.Reason, notes(slug)})
+	}
+	slices.SortFunc(d.Failures, func(a, b digestFailure) int {
+		return cmp.Or(cmp.Compare(b.Runs, a.Runs), cmp.Compare(a.Slug, b.Slug))
+	})
+
+	for slug, mg := range m.Games {
+		if len(mg.Fallbacks) == 0 {
+			continue
+		}
+		var assets []string
+		for asset := range mg.Fallbacks {
+			assets = append(assets, string(asset))
+		}
+		slices.Sort(assets)
+		d.Upgrades = append(d.Upgrades, digestUpgrade{slug, "fallback " + strings.Join(assets, ", ") + ", to be replaced once SteamGridDB has art", notes(slug)})
+	}
+	for _, item := range q.sorted_items() {
+		d.Upgrades = append(d.Upgrades, digestUpgrade{item.Slug, "quarantined, " + item.Reason + ", waiting for `review --batch`", notes(item.Slug)})
+	}
+	slices.SortStableFunc(d.Upgrades, func(a, b digestUpgrade) int { return cmp.Compare(a.Slug, b.Slug) })
+	return d
+}
+
+var DIGEST_FUNCS = map[string]any{
+	"date": func(t time.Time) string { return t.Local().Format(time.DateOnly) },
+	"join": strings.Join,
+}
+
+const DIGEST_MARKDOWN = `# Lutris cover art digest, {{date .Since}} to {{date .Until}}
+
+{{.Runs}} runs, {{.Assets}} assets fetched for {{.Games}} games.
+
+## New art
+{{range .Fetched}}{{if .Name}}
+### {{.Name}}
+{{end}}{{range .Items}}
+- {{.Game.Name}} (` + "`{{.Game.Slug}}`" + `): {{join .Assets ", "}}{{template "notes" .Notes}}
+{{- end}}
+{{end}}{{if not .Games}}
+Nothing new.
+{{end}}
+## Upgrades available
+{{range .Upgrades}}
+- ` + "`{{.Slug}}`" + `: {{.Reason}}{{template "notes" .Notes}}
+{{- else}}
+None.
+{{- end}}
+
+## Persistent failures
+{{range .Failures}}
+- {{.Name}} (` + "`{{.Slug}}`" + `), failed in {{.Runs}} runs, last on {{date .LastSeen}}: {{with .Code}}{{.}} {{end}}{{.Reason}}{{template "notes" .Notes}}
+{{- else}}
+None.
+{{- end}}
+{{define "notes"}}{{range .}}
+  - note: {{.}}
+{{- end}}{{end}}`
+
+const DIGEST_HTML = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Lutris cover art digest</title></head>
+<body>
+<h1>Lutris cover art digest, {{date .Since}} to {{date .Until}}</h1>
+<p>{{.Runs}} runs, {{.Assets}} assets fetched for {{.Games}} games.</p>
+<h2>New art</h2>
+{{if .Games}}{{range .Fetched}}{{if .Name}}<h3>{{.Name}}</h3>{{end}}<ul>
+{{range .Items}}<li>{{.Game.Name}} (<code>{{.Game.Slug}}</code>): {{join .Assets ", "}}{{template "notes" .Notes}}</li>
+{{end}}</ul>{{end}}{{else}}<p>Nothing new.</p>{{end}}
+<h2>Upgrades available</h2>
+{{if .Upgrades}}<ul>
+{{range .Upgrades}}<li><code>{{.Slug}}</code>: {{.Reason}}{{template "notes" .Notes}}</li>
+{{end}}</ul>{{else}}<p>None.</p>{{end}}
+<h2>Persistent failures</h2>
+{{if .Failures}}<ul>
+{{range .Failures}}<li>{{.Name}} (<code>{{.Slug}}</code>), failed in {{.Runs}} runs, last on {{date .LastSeen}}: {{with .Code}}{{.}} {{end}}{{.Reason}}{{template "notes" .Notes}}</li>
+{{end}}</ul>{{else}}<p>None.</p>{{end}}
+</body>
+</html>
+{{define "notes"}}{{with .}}<ul>{{range .}}<li>note: {{.}}</li>{{end}}</ul>{{end}}{{end}}`
+
+func render_digest(w io.Writer, d digest, format string) error {
+	switch format {
+	case DIGEST_FORMAT_MARKDOWN:
+		return texttemplate.Must(texttemplate.New("digest").Funcs(DIGEST_FUNCS).Parse(DIGEST_MARKDOWN)).Execute(w, d)
+	case DIGEST_FORMAT_HTML:
+		return htmltemplate.Must(htmltemplate.New("digest").Funcs(DIGEST_FUNCS).Parse(DIGEST_HTML)).Execute(w, d)
+	}
+	return fmt.Errorf("unknown format %q, expected %s or %s", format, DIGEST_FORMAT_MARKDOWN, DIGEST_FORMAT_HTML)
+}
+
+// email_digest sends the digest with the smtp_* settings, authenticating only
+// when a username is set.
+func email_digest(body []byte, format string, d digest) error {
+	host, from := setting_value("smtp_host"), setting_value("smtp_from")
+	var to []string
+	for _, addr := range strings.Split(setting_value("smtp_to"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if host == "" || from == "" || len(to) == 0 {
+		return errors.New("smtp_host, smtp_from and smtp_to have to be set")
+	}
+	var auth smtp.Auth
+	if username := setting_value("smtp_username"); username != "" {
+		auth = smtp.PlainAuth("", username, setting_value("smtp_password"), host)
+	}
+	contentType := "text/markdown"
+	if format == DIGEST_FORMAT_HTML {
+		contentType = "text/html"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: Lutris cover art digest, %s to %s\r\n", d.Since.Local().Format(time.DateOnly), d.Until.Local().Format(time.DateOnly))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s; charset=utf-8\r\n\r\n", contentType)
+	msg.Write(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n")))
+	return smtp.SendMail(net.JoinHostPort(host, setting_value("smtp_port")), auth, from, to, msg.Bytes())
+}
+
+func run_digest(args []string) {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	since := fs.Duration("since", 7*24*time.Hour, "period covered by the digest, up to now")
+	format := fs.String("format", DIGEST_FORMAT_MARKDOWN, "format of the digest, markdown or html")
+	output := fs.String("output", "", "file to write the digest to instead of the standard output")
+	email := fs.Bool("email", false, "email the digest with the smtp_* settings of the config file")
+	groupBy := fs.String("group-by", "", "group the new art by runner, service or category")
+	parse_flags(fs, args)
+	if err := check_group_by(*groupBy); err != nil {
+		log.Fatal("Invalid --group-by value", "err", err)
+	}
+	if *since > HISTORY_KEPT {
+		log.Warn("The run history only goes back " + HISTORY_KEPT.String())
+	}
+
+	var h runHistory
+	if err := read_state_file(HISTORY_FILE, &h); err != nil {
+		log.Fatal("An error occurred while reading the run history", "err", err)
+	}
+	m, err := load_manifest()
+	if err != nil {
+		log.Fatal("An error occurred while loading the manifest", "err", err)
+	}
+	q, err := load_quarantine()
+	if err != nil {
+		log.Fatal("An error occurred while loading quarantined games", "err", err)
+	}
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+	d := build_digest(h, m, q, cur, time.Now().Add(-*since), *groupBy)
+
+	var body bytes.Buffer
+	if err := render_digest(&body, d, *format); err != nil {
+		log.Fatal("Invalid --format value", "err", err)
+	}
+	switch {
+	case *output != "":
+		if err := os.WriteFile(*output, body.Bytes(), 0o644); err != nil {
+			log.Fatal("An error occurred while writing the digest", "err", err)
+		}
+		log.Info("Digest written", "file", *output)
+	case !*email:
+		os.Stdout.Write(body.Bytes())
+	}
+	if *email {
+		if err := email_digest(body.Bytes(), *format, d); err != nil {
+			log.Fatal("An error occurred while emailing the digest", "err", err)
+		}
+		log.Info("Digest emailed", "to", setting_value("smtp_to"))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// Error codes are stable, unlike the messages going with them, for scripts
+// reading --events json or the run history to branch on. Codes are never
+// renamed nor reused.
+const (
+	E_NO_MATCH           = "E_NO_MATCH"
+	E_AMBIGUOUS          = "E_AMBIGUOUS"
+	E_SKIPPED            = "E_SKIPPED"
+	E_HELD_BACK          = "E_HELD_BACK"
+	E_NO_IMAGE           = "E_NO_IMAGE"
+	E_BAD_FORMAT         = "E_BAD_FORMAT"
+	E_ANIMATED           = "E_ANIMATED"
+	E_BAD_DIMENSIONS     = "E_BAD_DIMENSIONS"
+	E_BAD_IMAGE          = "E_BAD_IMAGE"
+	E_RATE_LIMIT         = "E_RATE_LIMIT"
+	E_AUTH               = "E_AUTH"
+	E_NOT_FOUND          = "E_NOT_FOUND"
+	E_HTTP               = "E_HTTP"
+	E_MALFORMED_RESPONSE = "E_MALFORMED_RESPONSE"
+	E_NETWORK            = "E_NETWORK"
+	E_TIMEOUT            = "E_TIMEOUT"
+	E_IO                 = "E_IO"
+	E_UNKNOWN            = "E_UNKNOWN"
+)
+
+// codedError gives an error its code where it is known, the other ones
+// being told apart by their type in error_code.
+type codedError struct {
+	Code string
+	Err  error
+}
+
+func (e *codedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.Err
+}
+
+func with_code(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{Code: code, Err: err}
+}
+
+// error_code returns the code of the first error of the chain that has one.
+func error_code(err error) string {
+	var coded *codedError
+	var status *httpStatusError
+	var malformed *malformedResponseError
+	var netErr net.Error
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &coded):
+		return coded.Code
+	case errors.As(err, &malformed):
+		return E_MALFORMED_RESPONSE
+	case errors.As(err, &status):
+		return http_status_code(status.Code)
+	case errors.Is(err, context.DeadlineExceeded):
+		return E_TIMEOUT
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return E_TIMEOUT
+		}
+		return E_NETWORK
+	case errors.As(err, &pathErr), errors.As(err, &linkErr):
+		return E_IO
+	}
+	return E_UNKNOWN
+}
+
+func http_status_code(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return E_RATE_LIMIT
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return E_AUTH
+	case status == http.StatusNotFound:
+		return E_NOT_FOUND
+	}
+	return E_HTTP
+}
+
+// ERROR_CODES lists the codes in the order of the run summary.
+var ERROR_CODES = []string{
+	E_NO_MATCH, E_AMBIGUOUS, E_SKIPPED, E_HELD_BACK, E_NO_IMAGE, E_BAD_FORMAT, E_ANIMATED, E_BAD_DIMENSIONS, E_BAD_IMAGE,
+	E_RATE_LIMIT, E_AUTH, E_NOT_FOUND, E_HTTP, E_MALFORMED_RESPONSE, E_NETWORK, E_TIMEOUT, E_IO, E_UNKNOWN,
+}
+
+func error_code_index(code string) int {
+	if idx := slices.Index(ERROR_CODES, code); idx >= 0 {
+		return idx
+	}
+	return len(ERROR_CODES)
+}
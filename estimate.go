@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const ESTIMATE_DEFAULT_SAMPLE = 10
+
+// costEstimate adds up what processing the sampled games cost. Bytes only
+// covers the files whose size the server told, counted in Sized.
+type costEstimate struct {
+	Calls int64
+	Files map[assetType]int
+	Sized map[assetType]int
+	Bytes map[assetType]int64
+}
+
+func run_estimate(args []string) {
+	fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+	flags := add_fetch_flags(fs)
+	sample := fs.Int("sample", ESTIMATE_DEFAULT_SAMPLE, "number of games looked up on SteamGridDB to extrapolate from")
+	src := add_source_flags(fs)
+	parse_flags(fs, args)
+	opts := flags.options()
+	if *sample < 1 {
+		log.Fatal("Invalid --sample value", "err", "at least one game must be sampled")
+	}
+
+	lutrisDirs, db := open_lutris(*src)
+	defer db.Close()
+
+	games, err := load_library(db, *src)
+	if err != nil {
+		log.Fatal("An error occurred while fetching installed games", "err", err)
+	}
+	games = opts.Where.filter(games)
+	// fallbacks are not counted, replacing them is not part of the estimate
+	plan := plan_assets(lutrisDirs, opts, nil, games)
+	if len(plan.Items) == 0 {
+		log.Info("No game is missing assets, nothing to estimate")
+		return
+	}
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+
+	maxCalls := 0
+	for _, item := range plan.Items {
+		maxCalls += max_api_calls(cur, opts, item)
+	}
+	sampled := sample_items(plan.Items, *sample)
+	est := &costEstimate{Files: map[assetType]int{}, Sized: map[assetType]int{}, Bytes: map[assetType]int64{}}
+	for _, item := range sampled {
+		estimate_item(cur, opts, item, est)
+	}
+
+	scale := float64(len(plan.Items)) / float64(len(sampled))
+	calls := int(math.Round(float64(est.Calls) * scale))
+	log.Info(fmt.Sprintf("Estimate for %d games missing %d assets, extrapolated from %d of them", len(plan.Items), plan.pairs(), len(sampled)))
+	log.Info("API calls", "estimated", calls, "max", maxCalls, "duration", time.Duration(calls)*time.Second/SGDB_REQUESTS_PER_SECOND)
+	totalFiles, totalBytes, unsized := 0, int64(0), false
+	for _, kind := range opts.all_assets() {
+		files := int(math.Round(float64(est.Files[kind.Name]) * scale))
+		totalFiles += files
+		if files > 0 && est.Sized[kind.Name] == 0 {
+			log.Info(kind.Name, "files", files, "size", "unknown")
+			unsized = true
+			continue
+		}
+		var bytes int64
+		if files > 0 {
+			bytes = est.Bytes[kind.Name] / int64(est.Sized[kind.Name]) * int64(files)
+		}
+		log.Info(kind.Name, "files", files, "size", format_megabytes(bytes))
+		totalBytes += bytes
+	}
+	size := format_megabytes(totalBytes)
+	if unsized {
+		size = "more than " + size
+	}
+	log.Info("Downloads", "files", totalFiles, "size", size)
+	log.Info(fmt.Sprintf("Sampling used %d API calls", est.Calls))
+}
+
+// sample_items spreads the sample over the whole plan rather than taking the
+// first games, which would only be the oldest ones.
+func sample_items(items []planItem, size int) []planItem {
+	if size >= len(items) {
+		return items
+	}
+	sampled := make([]planItem, 0, size)
+	for i := range size {
+		sampled = append(sampled, items[i*len(items)/size])
+	}
+	return sampled
+}
+
+// max_api_calls is what a game costs when every match strategy has to be
+// tried, retries aside.
+func max_api_calls(cur *curation, opts fetchOptions, item planItem) int {
+	g := item.Game
+	if _, ok := opts.Catalog.images(g.Slug); ok {
+		return 0
+	}
+	calls := 0
+	if _, ok := cur.pinned(g.Slug); !ok {
+		for _, strategy := range MATCH_STRATEGIES {
+			if strategy.Applies(g) {
+				calls++
+			}
+		}
+	}
+	endpoints := map[string]bool{}
+	for _, kind := range item.Kinds {
+		if cur.override_url(g.Slug, kind.Name) == "" {
+			endpoints[kind.Endpoint] = true
+		}
+	}
+	return calls + len(endpoints)
+}
+
+// estimate_item goes through the same steps as a fetch, without quarantining
+// or recording anything, and asks for the size of the images it would
+// download instead of downloading them.
+func estimate_item(cur *curation, opts fetchOptions, item planItem, est *costEstimate) {
+	before := sgdbCalls.Load()
+	defer func() { est.Calls += sgdbCalls.Load() - before }()
+
+	g := item.Game
+	if images, ok := opts.Catalog.images(g.Slug); ok {
+		for _, kind := range item.Kinds {
+			imageUrl := cur.override_url(g.Slug, kind.Name)
+			if imageUrl == "" {
+				imageUrl = images[kind.Name]
+			}
+			if imageUrl != "" {
+				estimate_download(kind, g, imageUrl, est)
+			}
+		}
+		return
+	}
+	id, ok := cur.pinned(g.Slug)
+	if !ok {
+		candidates, err := search_candidates(g, cur, &strategyStats{Strategies: map[string]*strategyCounters{}}, opts.Race)
+		if err != nil {
+			log.Warn("Error while retrieving SteamGridDB game ID", "game", g.Slug, "err", err)
+			return
+		}
+		if len(candidates) == 0 || match_doubt(candidates) != "" {
+			return
+		}
+		id = candidates[0].Game.Id
+	}
+
+	images := map[string][]grid{}
+	for _, kind := range item.Kinds {
+		imageUrl := cur.override_url(g.Slug, kind.Name)
+		if imageUrl == "" {
+			if _, fetched := images[kind.Endpoint]; !fetched {
+				images[kind.Endpoint], _ = fetch_steamgriddb_images(kind.Endpoint, id, endpoint_dimensions(opts.assets_for(g), kind.Endpoint))
+			}
+			if img := select_image(images[kind.Endpoint], kind); img != nil {
+				imageUrl = img.Url
+			}
+		}
+		if imageUrl != "" {
+			estimate_download(kind, g, imageUrl, est)
+		}
+	}
+}
+
+func format_megabytes(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/1e6)
+}
+
+// estimate_download counts the image and asks for its size.
+func estimate_download(kind assetKind, g game, imageUrl string, est *costEstimate) {
+	est.Files[kind.Name]++
+	size, err := http_content_length(imageUrl)
+	if err != nil {
+		log.Warn("Error while measuring "+string(kind.Name), "game", g.Slug, "err", err)
+		return
+	}
+	if size >= 0 {
+		est.Sized[kind.Name]++
+		est.Bytes[kind.Name] += size
+	}
+}
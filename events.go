@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const EVENTS_FORMAT_JSON = "json"
+
+const RESULT_GAME_MATCHED = "game_matched"
+const RESULT_ASSET_DOWNLOADED = "asset_downloaded"
+const RESULT_GAME_FAILED = "game_failed"
+const RESULT_RUN_FINISHED = "run_finished"
+
+// RESULT_REQUEST_FAILED answers the requests the helper does not understand.
+const RESULT_REQUEST_FAILED = "request_failed"
+
+// Result is one step of the pipeline, a *GameMatched, *AssetDownloaded,
+// *GameFailed or *RunFinished, handed over in order to the consumer of the
+// stream of a run. Frontends such as GUIs or launcher plugins render progress
+// from them without parsing logs, or read them with --events json, one JSON
+// object per line on stdout while logs stay on stderr.
+type Result interface {
+	result_type() string
+	stamp(typ string, at time.Time)
+}
+
+// ResultHeader tells results apart in JSON, Time being when the result was
+// emitted.
+type ResultHeader struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+func (h *ResultHeader) stamp(typ string, at time.Time) {
+	h.Type, h.Time = typ, at
+}
+
+type GameMatched struct {
+	ResultHeader
+	Slug       gameSlug `json:"slug,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	SgdbId     int      `json:"sgdb_id,omitempty"`
+	Strategy   string   `json:"strategy,omitempty"`
+	Confidence float64  `json:"confidence,omitempty"`
+}
+
+type AssetDownloaded struct {
+	ResultHeader
+	Slug     gameSlug  `json:"slug,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Asset    assetType `json:"asset,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Url      string    `json:"url,omitempty"`
+	Fallback bool      `json:"fallback,omitempty"`
+}
+
+// GameFailed is a game that got no match, or one of its assets that could
+// not be written, Asset being empty for the former.
+type GameFailed struct {
+	ResultHeader
+	Slug  gameSlug  `json:"slug,omitempty"`
+	Name  string    `json:"name,omitempty"`
+	Asset assetType `json:"asset,omitempty"`
+	Url   string    `json:"url,omitempty"`
+	// Code is one of the error codes of errors.go.
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// RunFinished is the last result of a run, counting its failures by code.
+type RunFinished struct {
+	ResultHeader
+	Codes map[string]int `json:"codes,omitempty"`
+}
+
+// requestFailed answers the requests the helper does not understand, outside
+// of any run.
+type requestFailed struct {
+	ResultHeader
+	Reason string `json:"reason,omitempty"`
+}
+
+func (*GameMatched) result_type() string     { return RESULT_GAME_MATCHED }
+func (*AssetDownloaded) result_type() string { return RESULT_ASSET_DOWNLOADED }
+func (*GameFailed) result_type() string      { return RESULT_GAME_FAILED }
+func (*RunFinished) result_type() string     { return RESULT_RUN_FINISHED }
+func (*requestFailed) result_type() string   { return RESULT_REQUEST_FAILED }
+
+func parse_events_format(format string) (string, error) {
+	switch format {
+	case "", EVENTS_FORMAT_JSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown events format %q, expected json", format)
+}
+
+// resultStream hands results over to a single consumer, in the order the
+// workers produced them.
+type resultStream struct {
+	results chan Result
+	done    chan struct{}
+}
+
+// new_result_stream runs consume on the results of the run until the stream
+// is closed, the run waiting for it to be done on close.
+func new_result_stream(consume func(results <-chan Result)) *resultStream {
+	s := &resultStream{results: make(chan Result, 64), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		consume(s.results)
+		// a consumer returning early must not block the run
+		for range s.results {
+		}
+	}()
+	return s
+}
+
+func new_json_result_stream(w io.Writer) *resultStream {
+	return new_result_stream(func(results <-chan Result) {
+		enc := json.NewEncoder(w)
+		for res := range results {
+			if err := enc.Encode(res); err != nil {
+				log.Error("Error while writing event", "err", err)
+			}
+		}
+	})
+}
+
+func (s *resultStream) emit(res Result) {
+	if s == nil {
+		return
+	}
+	res.stamp(res.result_type(), time.Now())
+	s.results <- res
+}
+
+// close waits for the consumer to be done with the results already emitted.
+func (s *resultStream) close() {
+	if s == nil {
+		return
+	}
+	close(s.results)
+	<-s.done
+}
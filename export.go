@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const DEFAULT_EXPORT_PATH = "{{.Game.Slug}}/{{.Asset}}{{.Ext}}"
+const DEFAULT_EXPORT_METADATA_FILE = "metadata.txt"
+
+// UNSAFE_FILE_NAME_CHARS are the characters some file systems or frontends
+// do not allow in file names.
+var UNSAFE_FILE_NAME_CHARS = strings.NewReplacer("/", "-", "\\", "-", ":", " -", "*", "", "?", "", "\"", "", "<", "", ">", "", "|", "-")
+
+// exportedAsset is what the --path template is given for each asset file.
+type exportedAsset struct {
+	Game game
+	// Asset is the asset type, cover, banner, icon, hero or logo.
+	Asset assetType
+	// Ext is the extension of the file, with its dot.
+	Ext string
+	// File is the path of the file in the Lutris directories.
+	File string
+}
+
+// exportedGame is a game as the --metadata template sees it, Assets holding
+// the exported files by asset type, relative to the destination.
+type exportedGame struct {
+	Game   game
+	Assets map[assetType]string
+}
+
+// exportedLibrary is what the --metadata template is given.
+type exportedLibrary struct {
+	Dest  string
+	Games []exportedGame
+}
+
+// EXPORT_FUNCS are the functions templates can use on top of the built-in
+// ones.
+var EXPORT_FUNCS = template.FuncMap{
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"replace":  func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"filename": func(s string) string { return strings.TrimSpace(UNSAFE_FILE_NAME_CHARS.Replace(s)) },
+	"xml":      template.HTMLEscapeString,
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"date": func(layout string, unix int64) string {
+		if unix == 0 {
+			return ""
+		}
+		return time.Unix(unix, 0).Format(layout)
+	},
+}
+
+// run_export lays the art of the library out for another frontend, the path
+// of each file being given by a template, so frontends without a dedicated
+// integration can be populated. Files are copied, or symlinked with --link,
+// and those already exported are skipped. A metadata file listing the games,
+// like a gamelist.xml, can be written from a second template.
+func run_export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	add_lutris_version_flag(fs)
+	dest := fs.String("dest", "", "directory to lay the art out in")
+	pathTemplate := fs.String("path", DEFAULT_EXPORT_PATH, "template of the path of each asset file below --dest")
+	metadata := fs.String("metadata", "", "template file to write a metadata file listing the exported games from")
+	metadataFile := fs.String("metadata-file", DEFAULT_EXPORT_METADATA_FILE, "path of the metadata file below --dest")
+	link := fs.Bool("link", false, "symlink the files instead of copying them")
+	where := fs.String("where", "", "only export the games matching this expression")
+	dryRun := fs.Bool("dry-run", false, "list the files that would be exported without writing anything")
+	parse_flags(fs, args)
+	if *dest == "" || fs.NArg() > 0 {
+		log.Fatal("Usage: export --dest <dir> [--path <template>] [--metadata <template file>]")
+	}
+	pathTmpl, err := template.New("path").Funcs(EXPORT_FUNCS).Option("missingkey=error").Parse(*pathTemplate)
+	if err != nil {
+		log.Fatal("Invalid --path value", "err", err)
+	}
+	var metadataTmpl *template.Template
+	if *metadata != "" {
+		metadataTmpl, err = template.New(filepath.Base(*metadata)).Funcs(EXPORT_FUNCS).ParseFiles(*metadata)
+		if err != nil {
+			log.Fatal("Invalid --metadata value", "err", err)
+		}
+	}
+	if !filepath.IsLocal(*metadataFile) {
+		log.Fatal("Invalid --metadata-file value", "err", "not a path below --dest")
+	}
+	filter, err := parse_game_filter(*where)
+	if err != nil {
+		log.Fatal("Invalid --where value", "err", err)
+	}
+
+	lutrisDirs, err := get_lutris_dir()
+	if err != nil {
+		log.Fatal("An error occurred while retrieving Lutris directories", "err", err)
+	}
+	db, err := connect_to_lutris_db(lutrisDirs.DbFilePath)
+	if err != nil {
+		log.Fatal("An error occurred while connecting to Lutris database", "err", err)
+	}
+	defer db.Close()
+	lutrisDirs = apply_lutris_compat(db, lutrisDirs, true)
+	games, err := load_library(db, librarySource{})
+	if err != nil {
+		log.Fatal("An error occurred while fetching installed games", "err", err)
+	}
+	games = filter.filter(games)
+	sort_by_name(games, func(g game) string { return g.Name }, func(g game) string { return string(g.Slug) })
+
+	// two assets exported to the same path would overwrite each other
+	exportedBy := map[string]string{}
+	library := exportedLibrary{Dest: *dest}
+	exported, unchanged := 0, 0
+	for _, g := range games {
+		eg := exportedGame{Game: g, Assets: map[assetType]string{}}
+		for _, kind := range ASSET_KINDS {
+			files := asset_files(lutrisDirs, kind, g.Slug)
+			if len(files) == 0 {
+				continue
+			}
+			asset := exportedAsset{Game: g, Asset: kind.Name, Ext: filepath.Ext(files[0]), File: files[0]}
+			rel, err := export_path(pathTmpl, asset)
+			if err != nil {
+				log.Fatal("Invalid --path value", "game", g.Slug, "asset", kind.Name, "err", err)
+			}
+			id := string(g.Slug) + " " + string(kind.Name)
+			if other, ok := exportedBy[rel]; ok {
+				log.Fatal("Invalid --path value", "err", "two assets get the same path", "path", rel, "assets", other+", "+id)
+			}
+			exportedBy[rel] = id
+			eg.Assets[kind.Name] = rel
+
+			target := filepath.Join(*dest, rel)
+			if *dryRun {
+				fmt.Printf("%s -> %s\n", asset.File, target)
+				continue
+			}
+			written, err := export_file(asset.File, target, *link)
+			if err != nil {
+				log.Fatal("An error occurred while exporting "+string(kind.Name), "game", g.Slug, "file", target, "err", err)
+			}
+			if written {
+				exported++
+			} else {
+				unchanged++
+			}
+		}
+		library.Games = append(library.Games, eg)
+	}
+
+	if metadataTmpl != nil {
+		var b strings.Builder
+		if err := metadataTmpl.Execute(&b, library); err != nil {
+			log.Fatal("Invalid --metadata value", "err", err)
+		}
+		target := filepath.Join(*dest, *metadataFile)
+		if *dryRun {
+			fmt.Printf("metadata -> %s\n", target)
+		} else if err := write_export_metadata(target, b.String()); err != nil {
+			log.Fatal("An error occurred while writing the metadata file", "file", target, "err", err)
+		}
+	}
+	if !*dryRun {
+		log.Info(fmt.Sprintf("%d files exported, %d already up to date", exported, unchanged), "games", len(games), "dest", *dest)
+	}
+}
+
+// export_path renders the path of an asset, which must stay below the
+// destination whatever the names of the games.
+func export_path(tmpl *template.Template, asset exportedAsset) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, asset); err != nil {
+		return "", err
+	}
+	rel := filepath.Clean(strings.TrimSpace(b.String()))
+	if rel == "." || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%q is not a path below --dest", b.String())
+	}
+	return rel, nil
+}
+
+// export_file copies or links a file unless the target already is a copy of
+// it, copies keeping the modification time of the original to tell. It
+// reports whether the target was written.
+func export_file(src, target string, link bool) (bool, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return false, err
+	}
+	if link {
+		src, err = filepath.Abs(src)
+		if err != nil {
+			return false, err
+		}
+		if current, err := os.Readlink(target); err == nil && current == src {
+			return false, nil
+		}
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		return true, os.Symlink(src, target)
+	}
+
+	if existing, err := os.Lstat(target); err == nil && existing.Mode().IsRegular() &&
+		existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+		return false, nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	// renaming over the symlink of a previous --link export replaces it
+	if err := copy_to_file(f, target); err != nil {
+		return false, err
+	}
+	return true, os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+func write_export_metadata(target, content string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	return copy_to_file(strings.NewReader(content), target)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// DEFAULT_MAX_DIMENSION bounds the images of the asset types setting no
+// maximum, keeping decoding and writing them cheap.
+const DEFAULT_MAX_DIMENSION = 8192
+
+// imageFormat is an image format the fetcher can read and write, the one
+// place to add a new format before allowing it for an asset type through its
+// Mimes.
+type imageFormat struct {
+	Mime string
+	// Extensions recognize files of the format, the first one being what
+	// they are written with.
+	Extensions []string
+	// animated tells whether an image of the format holds an animation.
+	animated func(data []byte) bool
+}
+
+var IMAGE_FORMATS = []imageFormat{
+	{Mime: MIME_TYPE_JPEG, Extensions: []string{".jpg", ".jpeg"}},
+	{Mime: MIME_TYPE_PNG, Extensions: []string{".png"}, animated: is_apng},
+}
+
+func image_format(mime string) (imageFormat, bool) {
+	idx := slices.IndexFunc(IMAGE_FORMATS, func(f imageFormat) bool { return f.Mime == mime })
+	if idx < 0 {
+		return imageFormat{}, false
+	}
+	return IMAGE_FORMATS[idx], true
+}
+
+func mime_type_extension(mime string) string {
+	if f, ok := image_format(mime); ok {
+		return f.Extensions[0]
+	}
+	return ""
+}
+
+func mime_type_from_url(rawUrl string) string {
+	ext := strings.ToLower(path.Ext(rawUrl))
+	for _, f := range IMAGE_FORMATS {
+		if slices.Contains(f.Extensions, ext) {
+			return f.Mime
+		}
+	}
+	return ""
+}
+
+func (k assetKind) accepts_mime(mime string) bool {
+	return slices.Contains(k.Mimes, mime)
+}
+
+// extensions are the ones the files of the asset type can be written with,
+// in order of preference.
+func (k assetKind) extensions() []string {
+	var exts []string
+	for _, mime := range k.Mimes {
+		exts = append(exts, mime_type_extension(mime))
+	}
+	return exts
+}
+
+func (k assetKind) max_dimensions() (int, int) {
+	width, height := k.MaxWidth, k.MaxHeight
+	if width == 0 {
+		width = DEFAULT_MAX_DIMENSION
+	}
+	if height == 0 {
+		height = DEFAULT_MAX_DIMENSION
+	}
+	return width, height
+}
+
+// accepts tells whether a SteamGridDB image is a candidate for the asset
+// type, from what the API says of it, when ranking.
+func (k assetKind) accepts(img grid) bool {
+	maxWidth, maxHeight := k.max_dimensions()
+	return (k.Width == 0 || img.Width == k.Width) && k.accepts_mime(img.Mime) &&
+		img.Width <= maxWidth && img.Height <= maxHeight
+}
+
+// check_image validates a downloaded image against the policy of the asset
+// type, from its content rather than what the server said, returning its
+// actual MIME type. Formats the asset type does not accept pass when known,
+// to be transcoded.
+func (k assetKind) check_image(data []byte, declared string) (string, error) {
+	mime := http.DetectContentType(data)
+	f, ok := image_format(mime)
+	if !ok {
+		return "", with_code(E_BAD_FORMAT, fmt.Errorf("%s is not an image format Lutris reads, the server said %s", mime, declared))
+	}
+	if !k.Animated && f.animated != nil && f.animated(data) {
+		return "", with_code(E_ANIMATED, fmt.Errorf("animated %s are not allowed", k.Name+"s"))
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", with_code(E_BAD_IMAGE, fmt.Errorf("decoding image: %w", err))
+	}
+	maxWidth, maxHeight := k.max_dimensions()
+	if cfg.Width > maxWidth || cfg.Height > maxHeight {
+		return "", with_code(E_BAD_DIMENSIONS, fmt.Errorf("%dx%d is larger than the %dx%d allowed for %ss", cfg.Width, cfg.Height, maxWidth, maxHeight, k.Name))
+	}
+	return mime, nil
+}
+
+// sgdb_image_types asks SteamGridDB for animated images only when one of the
+// asset types of the endpoint allows them, the same for every request so the
+// image cache can answer.
+func sgdb_image_types(endpoint string) string {
+	if slices.ContainsFunc(ASSET_KINDS, func(k assetKind) bool { return k.Endpoint == endpoint && k.Animated }) {
+		return "static,animated"
+	}
+	return "static"
+}
+
+// is_apng looks for the animation control chunk, which comes before the
+// image data in animated PNGs.
+func is_apng(data []byte) bool {
+	const signatureLength = 8
+	// in 64 bits, a chunk length near 4 GiB overflowing int on 32-bit
+	// systems
+	size := int64(len(data))
+	for pos := int64(signatureLength); pos+8 <= size; {
+		length := int64(binary.BigEndian.Uint32(data[pos:]))
+		switch string(data[pos+4 : pos+8]) {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+		if length > size {
+			return false
+		}
+		pos += 12 + length
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+// gameSlug identifies a game of the Lutris library, naming its art files and
+// keying the state files. lutris_slug makes one from a name.
+type gameSlug string
+
+// to_slugs types the slugs given on the command line.
+func to_slugs(args []string) []gameSlug {
+	slugs := make([]gameSlug, len(args))
+	for i, arg := range args {
+		slugs[i] = gameSlug(arg)
+	}
+	return slugs
+}
+
+// gameService is the Lutris service a game was imported from, the service
+// column of the games table. Games added by hand or by an installer have
+// none.
+type gameService string
+
+// the services of Lutris, under their names in the database
+const (
+	SERVICE_NONE          gameService = ""
+	SERVICE_LUTRIS        gameService = "lutris"
+	SERVICE_STEAM         gameService = "steam"
+	SERVICE_STEAM_WINDOWS gameService = "steamwindows"
+	SERVICE_GOG           gameService = "gog"
+	SERVICE_EGS           gameService = "egs"
+	SERVICE_EA_APP        gameService = "ea_app"
+	SERVICE_ORIGIN        gameService = "origin"
+	SERVICE_UBISOFT       gameService = "ubisoft"
+	SERVICE_HUMBLE_BUNDLE gameService = "humblebundle"
+	SERVICE_ITCHIO        gameService = "itchio"
+	SERVICE_BATTLENET     gameService = "battlenet"
+	SERVICE_AMAZON        gameService = "amazon"
+	SERVICE_XDG           gameService = "xdg"
+)
+
+// gameRunner is what Lutris runs a game with, the runner column of the games
+// table. Runners are plugins, so games can have runners missing below.
+type gameRunner string
+
+// the runners of Lutris games are most often added with, under their names
+// in the database
+const (
+	RUNNER_NONE      gameRunner = ""
+	RUNNER_LINUX     gameRunner = "linux"
+	RUNNER_WINE      gameRunner = "wine"
+	RUNNER_STEAM     gameRunner = "steam"
+	RUNNER_FLATPAK   gameRunner = "flatpak"
+	RUNNER_DOSBOX    gameRunner = "dosbox"
+	RUNNER_SCUMMVM   gameRunner = "scummvm"
+	RUNNER_RETROARCH gameRunner = "retroarch"
+	RUNNER_MAME      gameRunner = "mame"
+	RUNNER_DOLPHIN   gameRunner = "dolphin"
+	RUNNER_PCSX2     gameRunner = "pcsx2"
+	RUNNER_WEB       gameRunner = "web"
+)
+
+// game is a game of the Lutris library, as read from its database and
+// shared by the providers, the state files and the reports.
+type game struct {
+	Id        int64
+	Slug      gameSlug
+	Name      string
+	Service   gameService
+	ServiceId string
+	Runner    gameRunner
+	Updated   int64
+	// LastPlayed is a Unix timestamp and Playtime is in hours.
+	LastPlayed int64
+	Playtime   float64
+	// Categories are the ones the user put the game in within Lutris.
+	Categories []string `json:",omitempty"`
+	// Hidden games are the ones in the .hidden category of Lutris.
+	Hidden bool `json:",omitempty"`
+}
@@ -1,11 +1,15 @@
 module github.com/gobtronic/lutris-cover-art-fetcher
 
-go 1.24.5
+go 1.25
 
 require (
 	github.com/charmbracelet/log v0.4.2
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.20.1
 	github.com/mattn/go-sqlite3 v1.14.28
+	github.com/muesli/termenv v0.16.0
+	golang.org/x/image v0.27.0
+	golang.org/x/text v0.25.0
 )
 
 require (
@@ -19,7 +23,6 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
-	github.com/muesli/termenv v0.16.0 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d // indirect
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const HELPER_SOCKET_NAME = "lutris-cover-art-fetcher.sock"
+
+// SD_LISTEN_FDS_START is the first file descriptor systemd passes with
+// socket activation.
+const SD_LISTEN_FDS_START = 3
+
+// helper answers the requests of Lutris wrapper scripts and other desktop
+// tools over a unix socket, one fetch at a time. It keeps a single run with
+// its curation, manifest and caches loaded between requests, so only the
+// first request waits for the tool to start.
+type helper struct {
+	mu     sync.Mutex
+	db     *sql.DB
+	run    *fetchRun
+	active atomic.Int32
+}
+
+// run_helper listens on the socket systemd passes when socket activated, on
+// --socket otherwise. Each line sent is a request, "fetch <slug>..." fetching
+// the missing art of the games like a run would, and is answered with the
+// results of --events json, the last one being run_finished.
+func run_helper(args []string) {
+	fs := flag.NewFlagSet("helper", flag.ExitOnError)
+	flags := add_fetch_flags(fs)
+	socket := fs.String("socket", default_helper_socket(), "unix socket to listen on when not socket activated")
+	idle := fs.Duration("idle-timeout", 0, "exit after this long without requests, systemd starting the helper again on the next one, e.g. 10m")
+	parse_flags(fs, args)
+	if flag_set(fs, "profile") {
+		log.Fatal("Invalid --profile value", "err", "the helper runs until stopped, profile a fetch instead")
+	}
+	opts := flags.options()
+
+	lutrisDirs, db := open_lutris(librarySource{})
+	defer db.Close()
+	ln, err := helper_listener(*socket)
+	if err != nil {
+		log.Fatal("An error occurred while listening on the helper socket", "err", err)
+	}
+	defer ln.Close()
+	h := &helper{db: db, run: new_fetch_run(opts, lutrisDirs)}
+	log.Info("Waiting for requests", "socket", ln.Addr())
+
+	for {
+		if idle := *idle; idle > 0 {
+			ln.SetDeadline(time.Now().Add(idle))
+		}
+		conn, err := ln.Accept()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if h.active.Load() > 0 {
+				continue
+			}
+			log.Info("Exiting after being idle", "for", *idle)
+			return
+		}
+		if err != nil {
+			log.Fatal("An error occurred while accepting a request", "err", err)
+		}
+		h.active.Add(1)
+		go func() {
+			defer report_panic()
+			defer h.active.Add(-1)
+			h.serve(conn)
+		}()
+	}
+}
+
+func default_helper_socket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, HELPER_SOCKET_NAME)
+}
+
+// helper_listener takes over the socket of systemd socket activation, or
+// listens on the socket file, only the user being allowed to connect.
+func helper_listener(socket string) (*net.UnixListener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n != 1 {
+			return nil, fmt.Errorf("expected one socket from systemd, got %d", n)
+		}
+		// not passed on to the processes the tool starts, like image viewers
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		f := os.NewFile(SD_LISTEN_FDS_START, "systemd socket")
+		defer f.Close()
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, err
+		}
+		unixLn, ok := ln.(*net.UnixListener)
+		if !ok {
+			ln.Close()
+			return nil, errors.New("the socket passed by systemd is not a unix socket")
+		}
+		return unixLn, nil
+	}
+
+	// a socket file left by a helper that did not exit cleanly
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another helper is listening on %s", socket)
+	}
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func (h *helper) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0:
+			continue
+		case fields[0] == "fetch" && len(fields) > 1:
+			h.fetch(conn, to_slugs(fields[1:]))
+		default:
+			request_failed(conn, "unknown request, expected fetch <slug>...")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warn("Error while reading a request", "err", err)
+	}
+}
+
+// fetch runs the games through the run kept by the helper, requests being
+// answered one after the other as a run processes one plan at a time.
+func (h *helper) fetch(w io.Writer, slugs []gameSlug) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	games, err := load_library(h.db, librarySource{})
+	if err != nil {
+		log.Error("Error while fetching installed games", "err", err)
+		request_failed(w, "could not read the Lutris library: "+err.Error())
+		return
+	}
+	log.Info("Fetching on request", "games", slugs)
+	r := h.run
+	r.summary = new_run_summary()
+	r.results = new_json_result_stream(w)
+	selected := select_slugs(games, slugs)
+	for _, slug := range slugs {
+		if !slices.ContainsFunc(selected, func(g game) bool { return g.Slug == slug }) {
+			r.summary.add_failed(game{Slug: slug}, E_NOT_FOUND, "not in the Lutris library")
+			r.results.emit(&GameFailed{Slug: slug, Code: E_NOT_FOUND, Reason: "not in the Lutris library"})
+		}
+	}
+	r.process_plan(plan_assets(r.dirs, r.opts, r.manifest, r.opts.Where.filter(selected)))
+	r.close_results()
+	r.results = nil
+	r.commit_db_writes(h.db)
+	r.save()
+}
+
+func request_failed(w io.Writer, reason string) {
+	res := &requestFailed{Reason: reason}
+	res.stamp(res.result_type(), time.Now())
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Warn("Error while answering a request", "err", err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const SGDB_REQUESTS_PER_SECOND = 4
+const HTTP_MAX_RETRIES = 5
+const HTTP_INITIAL_BACKOFF = time.Second
+
+// HTTP_MAX_BODY_SIZE bounds responses, animated heroes being the largest
+// images served, as the API can be any server given with --api-url.
+const HTTP_MAX_BODY_SIZE = 64 << 20
+
+// sgdbLimiter is shared by every worker so a 429 pauses all of them.
+var sgdbLimiter = &rateLimiter{interval: time.Second / SGDB_REQUESTS_PER_SECOND}
+
+// sgdbCalls counts the requests sent to the SteamGridDB API, retries
+// included, since each of them counts against the quota.
+var sgdbCalls atomic.Int64
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	return sleep(ctx, delay)
+}
+
+// sleep returns early with the context error when ctx is canceled.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) pause_until(t time.Time) {
+	l.mu.Lock()
+	if l.next.Before(t) {
+		l.next = t
+	}
+	l.mu.Unlock()
+}
+
+func http_get(ctx context.Context, rawUrl string, sgdb bool) ([]byte, error) {
+	body, _, err := http_get_with_status(ctx, rawUrl, sgdb)
+	return body, err
+}
+
+// http_get_with_status retries network errors, 429 and 5xx responses with
+// exponential backoff, honoring Retry-After when the server sends one. It
+// gives up as soon as ctx is canceled.
+func http_get_with_status(ctx context.Context, rawUrl string, sgdb bool) ([]byte, int, error) {
+	backoff := HTTP_INITIAL_BACKOFF
+	for attempt := 1; ; attempt++ {
+		if sgdb {
+			start := time.Now()
+			err := sgdbLimiter.wait(ctx)
+			timings.since(TIMING_RATE_LIMIT, start)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		body, status, retryAfter, err := http_get_once(ctx, rawUrl, sgdb)
+		if err == nil || retryAfter < 0 || attempt >= HTTP_MAX_RETRIES || ctx.Err() != nil {
+			return body, status, err
+		}
+		delay := max(backoff, retryAfter)
+		log.Warn("Request failed, retrying", "url", rawUrl, "attempt", attempt, "delay", delay, "err", err)
+		if sgdb {
+			sgdbLimiter.pause_until(time.Now().Add(delay))
+		}
+		start := time.Now()
+		err = sleep(ctx, delay)
+		timings.since(TIMING_RATE_LIMIT, start)
+		if err != nil {
+			return nil, 0, err
+		}
+		backoff *= 2
+	}
+}
+
+// http_get_once returns a negative retryAfter when the error is not worth
+// retrying.
+func http_get_once(ctx context.Context, rawUrl string, sgdb bool) ([]byte, int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, 0, -1, err
+	}
+	stage := TIMING_DOWNLOAD
+	if sgdb {
+		req.Header.Add("Authorization", "Bearer "+SGDB_API_KEY)
+		sgdbCalls.Add(1)
+		stage = TIMING_API
+	}
+	defer timings.since(stage, time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, HTTP_MAX_BODY_SIZE+1))
+	if err != nil {
+		return nil, resp.StatusCode, 0, err
+	}
+	if len(body) > HTTP_MAX_BODY_SIZE {
+		return nil, resp.StatusCode, -1, fmt.Errorf("response larger than %d MiB", HTTP_MAX_BODY_SIZE>>20)
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, resp.StatusCode, parse_retry_after(resp.Header.Get("Retry-After")), &httpStatusError{resp.StatusCode, resp.Status}
+	case resp.StatusCode >= 400:
+		return nil, resp.StatusCode, -1, &httpStatusError{resp.StatusCode, resp.Status}
+	}
+	return body, resp.StatusCode, 0, nil
+}
+
+// http_content_length asks for the size of a file without downloading it,
+// returning -1 when the server does not tell.
+func http_content_length(rawUrl string) (int64, error) {
+	resp, err := http.Head(rawUrl)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return -1, &httpStatusError{resp.StatusCode, resp.Status}
+	}
+	return resp.ContentLength, nil
+}
+
+type httpStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *httpStatusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+func is_not_found(err error) bool {
+	var statusErr *httpStatusError
+	return errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound
+}
+
+func parse_retry_after(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		// a date already past after clock skew is retried right away
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const IGDB_API_URL = "https://api.igdb.com/v4/"
+const IGDB_TOKEN_URL = "https://id.twitch.tv/oauth2/token"
+const IGDB_SCREENSHOT_URL = "https://images.igdb.com/igdb/image/upload/t_1080p/%s.jpg"
+
+// igdb authenticates with the credentials of a Twitch application, IGDB
+// being run by Twitch.
+var igdb = &igdbClient{}
+
+type igdbClient struct {
+	mu           sync.Mutex
+	clientId     string
+	clientSecret string
+	token        string
+	expiresAt    time.Time
+
+	// names caches the names found for each title, as the strategy scoring
+	// candidates needs them again after the search.
+	namesMu sync.Mutex
+	names   map[string][]string
+}
+
+// load_igdb_credentials reads IGDB_CLIENT_ID and IGDB_CLIENT_SECRET.
+func load_igdb_credentials() error {
+	igdb.clientId = os.Getenv("IGDB_CLIENT_ID")
+	igdb.clientSecret = os.Getenv("IGDB_CLIENT_SECRET")
+	if igdb.clientId == "" || igdb.clientSecret == "" {
+		return errors.New("IGDB_CLIENT_ID and IGDB_CLIENT_SECRET must be set to the credentials of a Twitch application")
+	}
+	return nil
+}
+
+func (c *igdbClient) access_token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expiresAt) {
+		return c.token, nil
+	}
+	params := url.Values{}
+	params.Set("client_id", c.clientId)
+	params.Set("client_secret", c.clientSecret)
+	params.Set("grant_type", "client_credentials")
+	body, err := http_post(ctx, IGDB_TOKEN_URL+"?"+params.Encode(), nil, "")
+	if err != nil {
+		return "", fmt.Errorf("authenticating with Twitch: %w", err)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.AccessToken == "" {
+		return "", malformed_response(IGDB_TOKEN_URL, http.StatusOK, body, errors.New("no access token"))
+	}
+	c.token = resp.AccessToken
+	// renewing a minute early avoids using a token expiring mid-request
+	c.expiresAt = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// query sends an Apicalypse query to an IGDB endpoint.
+func (c *igdbClient) query(ctx context.Context, endpoint, query string) ([]byte, error) {
+	token, err := c.access_token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{"Client-ID": c.clientId, "Authorization": "Bearer " + token}
+	return http_post(ctx, IGDB_API_URL+endpoint, headers, query)
+}
+
+// first_screenshot returns the URL of the first screenshot of the game IGDB
+// finds for the name, or an empty string when it has none.
+func (c *igdbClient) first_screenshot(ctx context.Context, name string) (string, error) {
+	body, err := c.query(ctx, "games", fmt.Sprintf("search \"%s\"; fields name,screenshots.image_id; where screenshots != null; limit 1;", igdb_escape(name)))
+	if err != nil {
+		return "", err
+	}
+	var games []struct {
+		Name        string `json:"name"`
+		Screenshots []struct {
+			ImageId string `json:"image_id"`
+		} `json:"screenshots"`
+	}
+	if err := json.Unmarshal(body, &games); err != nil {
+		return "", malformed_response(IGDB_API_URL+"games", http.StatusOK, body, err)
+	}
+	if len(games) == 0 || len(games[0].Screenshots) == 0 || games[0].Screenshots[0].ImageId == "" {
+		return "", nil
+	}
+	return fmt.Sprintf(IGDB_SCREENSHOT_URL, games[0].Screenshots[0].ImageId), nil
+}
+
+// IGDB_MAX_ALTERNATIVE_NAMES bounds the SteamGridDB searches made with the
+// names of a game.
+const IGDB_MAX_ALTERNATIVE_NAMES = 3
+
+// alternative_names returns the names IGDB knows the game it finds for the
+// title under, its main one first, leaving out the title itself and the
+// names SteamGridDB is unlikely to know, not being ASCII. Games only found
+// by a loose search have no alternative names.
+func (c *igdbClient) alternative_names(ctx context.Context, title string) ([]string, error) {
+	c.namesMu.Lock()
+	names, ok := c.names[title]
+	c.namesMu.Unlock()
+	if ok {
+		return names, nil
+	}
+	body, err := c.query(ctx, "games", fmt.Sprintf("search \"%s\"; fields name,alternative_names.name; limit 1;", igdb_escape(title)))
+	if err != nil {
+		return nil, err
+	}
+	var games []struct {
+		Name             string `json:"name"`
+		AlternativeNames []struct {
+			Name string `json:"name"`
+		} `json:"alternative_names"`
+	}
+	if err := json.Unmarshal(body, &games); err != nil {
+		return nil, malformed_response(IGDB_API_URL+"games", http.StatusOK, body, err)
+	}
+	names = []string{}
+	var candidates []string
+	if len(games) > 0 {
+		candidates = append(candidates, games[0].Name)
+		for _, alt := range games[0].AlternativeNames {
+			candidates = append(candidates, alt.Name)
+		}
+	}
+	// the game IGDB finds must go by the title under one of its names, or
+	// its other names would lead to another game
+	if slices.ContainsFunc(candidates, func(name string) bool { return match_confidence(title, name) >= MATCH_CONFIDENCE_THRESHOLD }) {
+		for _, name := range candidates {
+			name = strings.TrimSpace(name)
+			if name == "" || needs_transliteration(name) || strings.EqualFold(name, title) || slices.Contains(names, name) {
+				continue
+			}
+			names = append(names, name)
+			if len(names) == IGDB_MAX_ALTERNATIVE_NAMES {
+				break
+			}
+		}
+	}
+	c.namesMu.Lock()
+	if c.names == nil {
+		c.names = map[string][]string{}
+	}
+	c.names[title] = names
+	c.namesMu.Unlock()
+	return names, nil
+}
+
+// cached_alternative_names returns what alternative_names found for the
+// title, without asking IGDB.
+func (c *igdbClient) cached_alternative_names(title string) []string {
+	c.namesMu.Lock()
+	defer c.namesMu.Unlock()
+	return c.names[title]
+}
+
+// igdb_escape quotes a title for an Apicalypse search.
+func igdb_escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+func http_post(ctx context.Context, rawUrl string, headers map[string]string, body string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawUrl, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	defer timings.since(TIMING_API, time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, &httpStatusError{resp.StatusCode, resp.Status}
+	}
+	return data, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/image/draw"
+)
+
+const JPEG_QUALITY = 90
+
+// imageProcessing holds the optional transformations applied to downloaded
+// images before they are written.
+type imageProcessing struct {
+	Resize    bool
+	Transcode string
+}
+
+func (p imageProcessing) enabled() bool {
+	return p.Resize || p.Transcode != ""
+}
+
+// cpuPool runs image processing apart from the network workers, with as many
+// goroutines as CPUs since it is CPU-bound. Its queue is bounded too, each job
+// holding a downloaded image, so submit blocks the network workers only once
+// the pool is that far behind.
+type cpuPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+	once sync.Once
+	size int
+}
+
+func new_cpu_pool(size int) *cpuPool {
+	return &cpuPool{jobs: make(chan func(), 2*size), size: size}
+}
+
+// submit starts the pool on first use, most runs processing nothing.
+func (p *cpuPool) submit(job func()) {
+	p.once.Do(func() {
+		for range p.size {
+			go func() {
+				defer report_panic()
+				for job := range p.jobs {
+					job()
+					p.wg.Done()
+				}
+			}()
+		}
+	})
+	p.wg.Add(1)
+	p.jobs <- job
+}
+
+// wait returns once every submitted job is done, the pool staying usable.
+func (p *cpuPool) wait() {
+	p.wg.Wait()
+}
+
+func parse_transcode_format(format string) (string, error) {
+	switch format {
+	case "":
+		return "", nil
+	case "jpg", "jpeg":
+		return MIME_TYPE_JPEG, nil
+	case "png":
+		return MIME_TYPE_PNG, nil
+	}
+	return "", fmt.Errorf("unknown image format %q, expected jpg or png", format)
+}
+
+// process_image_safely falls back to the original image when processing
+// fails and it is already in a format acceptable for the asset kind.
+func process_image_safely(data []byte, mime string, kind assetKind, p imageProcessing, slug gameSlug) ([]byte, string, error) {
+	defer timings.since(TIMING_IMAGE, time.Now())
+	processed, processedMime, err := process_image(data, mime, kind, p)
+	if err == nil {
+		return processed, processedMime, nil
+	}
+	if kind.accepts_mime(mime) {
+		log.Warn("Image processing failed, keeping the original image", "game", slug, "asset", kind.Name, "err", err)
+		return data, mime, nil
+	}
+	return nil, "", err
+}
+
+func process_image(data []byte, mime string, kind assetKind, p imageProcessing) ([]byte, string, error) {
+	targetMime := mime
+	if p.Transcode != "" && kind.accepts_mime(p.Transcode) {
+		targetMime = p.Transcode
+	}
+	// formats the asset type does not accept are always transcoded
+	if !kind.accepts_mime(targetMime) {
+		targetMime = kind.Mimes[0]
+	}
+	resize := p.Resize && kind.TargetWidth > 0 && kind.TargetHeight > 0
+	if !resize && targetMime == mime {
+		return data, mime, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", with_code(E_BAD_IMAGE, fmt.Errorf("decoding image: %w", err))
+	}
+	if resize {
+		img = resize_to_fill(img, kind.TargetWidth, kind.TargetHeight)
+	}
+	encoded, err := encode_image(img, targetMime)
+	if err != nil {
+		return nil, "", with_code(E_BAD_IMAGE, fmt.Errorf("encoding image: %w", err))
+	}
+	return encoded, targetMime, nil
+}
+
+// resize_to_fill scales the image to cover the target size, cropping what
+// overflows around the center so the aspect ratio is kept.
+func resize_to_fill(img image.Image, width, height int) image.Image {
+	src := img.Bounds()
+	if src.Dx() == width && src.Dy() == height {
+		return img
+	}
+	crop := src
+	if src.Dx()*height > src.Dy()*width {
+		cropWidth := src.Dy() * width / height
+		crop.Min.X += (src.Dx() - cropWidth) / 2
+		crop.Max.X = crop.Min.X + cropWidth
+	} else {
+		cropHeight := src.Dx() * height / width
+		crop.Min.Y += (src.Dy() - cropHeight) / 2
+		crop.Max.Y = crop.Min.Y + cropHeight
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Over, nil)
+	return dst
+}
+
+func encode_image(img image.Image, mime string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch mime {
+	case MIME_TYPE_JPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEG_QUALITY})
+	case MIME_TYPE_PNG:
+		err = png.Encode(&buf, img)
+	default:
+		err = with_code(E_BAD_FORMAT, fmt.Errorf("cannot encode %s images", mime))
+	}
+	return buf.Bytes(), err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+const INTERACTIVE_MAX_CHOICES = 10
+
+// choose_candidate lets the user pick the matching game, searching again with
+// their own terms when none of the candidates is right.
+func choose_candidate(in *bufio.Scanner, g game, candidates []candidate, cur *curation, viewer string, diverse bool) (candidate, bool) {
+	for {
+		fmt.Printf("\n%s (%q)\n", g.Slug, g.Name)
+		shown := candidates[:min(len(candidates), INTERACTIVE_MAX_CHOICES)]
+		for i, c := range shown {
+			fmt.Printf("  %d) %s (#%d, %.0f%%%s)\n", i+1, c.Game.Name, c.Game.Id, c.Confidence*100, verified_mark(c.Game))
+		}
+		if len(shown) == 0 {
+			fmt.Println("  no candidate found")
+		}
+		if viewer != "" {
+			fmt.Print("Pick a game [1], v<n> to preview its grids, /<terms> to search again, s to skip > ")
+		} else {
+			fmt.Print("Pick a game [1], /<terms> to search again, s to skip > ")
+		}
+		if !in.Scan() {
+			return candidate{}, false
+		}
+		answer := strings.TrimSpace(in.Text())
+		switch {
+		case answer == "s":
+			return candidate{}, false
+		case viewer != "" && strings.HasPrefix(answer, "v"):
+			if idx, ok := parse_choice(strings.TrimSpace(answer[1:]), len(shown)); ok {
+				if err := preview_game_grids(viewer, shown[idx].Game.Id, diverse); err != nil {
+					log.Error("Error while previewing grids", "err", err)
+				}
+			}
+		case strings.HasPrefix(answer, "/"):
+			games, err := search_steamgriddb_games(context.Background(), strings.TrimSpace(answer[1:]))
+			if err != nil {
+				log.Error("Error while searching SteamGridDB", "err", err)
+				continue
+			}
+			candidates = rank_candidates(g, games, cur, "manual-search")
+		default:
+			if idx, ok := parse_choice(answer, len(shown)); ok {
+				return shown[idx], true
+			}
+		}
+	}
+}
+
+func choose_grid(in *bufio.Scanner, g game, kind assetKind, images []grid, viewer string, diverse bool) (*grid, bool) {
+	matching := images_for_kind(images, kind)
+	if diverse {
+		matching = diversify(matching)
+	}
+	if len(matching) == 0 {
+		return nil, true
+	}
+	shown := matching[:min(len(matching), INTERACTIVE_MAX_CHOICES)]
+	for {
+		fmt.Printf("%s images for %s:\n", kind.Name, g.Slug)
+		for i, grid := range shown {
+			fmt.Printf("  %d) %s (%dx%d, %s, %s by %s)\n", i+1, grid.Url, grid.Width, grid.Height, grid.Mime, grid.Style, grid.Author.Name)
+		}
+		if viewer != "" {
+			fmt.Print("Pick an image [1], v to preview them, s to skip > ")
+		} else {
+			fmt.Print("Pick an image [1], s to skip > ")
+		}
+		if !in.Scan() {
+			return nil, false
+		}
+		answer := strings.TrimSpace(in.Text())
+		if answer == "s" {
+			return nil, false
+		}
+		if viewer != "" && answer == "v" {
+			if err := open_in_viewer(viewer, grid_thumbs(shown)); err != nil {
+				log.Error("Error while previewing grids", "err", err)
+			}
+			continue
+		}
+		if idx, ok := parse_choice(answer, len(shown)); ok {
+			return &shown[idx], true
+		}
+	}
+}
+
+func verified_mark(data gameData) string {
+	if data.Verified {
+		return ", verified"
+	}
+	return ""
+}
+
+// parse_choice maps a 1-based answer to an index, an empty answer meaning the
+// first choice.
+func parse_choice(answer string, count int) (int, bool) {
+	if count == 0 {
+		return 0, false
+	}
+	if answer == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(answer)
+	if err != nil || n < 1 || n > count {
+		return 0, false
+	}
+	return n - 1, true
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const LIBRARY_FILE = "library.json"
+
+// cachedLibrary is the last game list read from the Lutris database, used
+// when Lutris keeps it locked.
+type cachedLibrary struct {
+	ReadAt time.Time `json:"read_at"`
+	Games  []game    `json:"games"`
+}
+
+// load_library falls back to the cached game list when the database is
+// locked, so scheduled runs still process the games known so far. Backups
+// are never cached, not being the library of the user.
+func load_library(db *sql.DB, src librarySource) ([]game, error) {
+	games, err := select_games(db)
+	if src.Backup != "" {
+		return games, err
+	}
+	if err == nil {
+		if err := write_state_file(LIBRARY_FILE, cachedLibrary{ReadAt: time.Now(), Games: games}); err != nil {
+			log.Warn("Error while caching the game list", "err", err)
+		}
+		return games, nil
+	}
+	if !is_db_locked(err) {
+		return nil, err
+	}
+	var cached cachedLibrary
+	if cacheErr := read_state_file(LIBRARY_FILE, &cached); cacheErr != nil || cached.ReadAt.IsZero() {
+		return nil, err
+	}
+	log.Warn("Lutris database is locked, using the game list cached at "+cached.ReadAt.Local().Format(time.DateTime)+", games added since are left for the next run", "err", err)
+	return cached.Games, nil
+}
+
+// is_db_locked goes by the SQLite message for SQLITE_BUSY and SQLITE_LOCKED,
+// sqlite3.Error only existing in cgo builds.
+func is_db_locked(err error) bool {
+	return strings.Contains(err.Error(), "database is locked") || strings.Contains(err.Error(), "database table is locked")
+}
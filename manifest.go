@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const MANIFEST_FILE = "manifest.json"
+
+// manifest records, for every game, how its SteamGridDB match was found.
+type manifest struct {
+	mu sync.Mutex
+
+	Games map[gameSlug]*manifestGame `json:"games"`
+}
+
+type manifestGame struct {
+	SgdbId     int       `json:"sgdb_id"`
+	Name       string    `json:"name"`
+	Strategy   string    `json:"strategy"`
+	Confidence float64   `json:"confidence"`
+	MatchedAt  time.Time `json:"matched_at"`
+	// Fallbacks are the assets, by name, standing in for art SteamGridDB did
+	// not have, with where they come from. They are replaced as soon as it
+	// has some.
+	Fallbacks map[assetType]string `json:"fallbacks,omitempty"`
+}
+
+func load_manifest() (*manifest, error) {
+	m := &manifest{}
+	err := read_state_file(MANIFEST_FILE, m)
+	if m.Games == nil {
+		m.Games = map[gameSlug]*manifestGame{}
+	}
+	return m, err
+}
+
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return write_state_file(MANIFEST_FILE, m)
+}
+
+func (m *manifest) record_match(slug gameSlug, c candidate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var fallbacks map[assetType]string
+	if prev := m.Games[slug]; prev != nil {
+		fallbacks = prev.Fallbacks
+	}
+	m.Games[slug] = &manifestGame{
+		SgdbId:     c.Game.Id,
+		Name:       c.Game.Name,
+		Strategy:   c.Strategy,
+		Confidence: c.Confidence,
+		MatchedAt:  time.Now(),
+		Fallbacks:  fallbacks,
+	}
+}
+
+func (m *manifest) record_fallback(slug gameSlug, asset assetType, source string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mg := m.Games[slug]
+	if mg == nil {
+		mg = &manifestGame{}
+		m.Games[slug] = mg
+	}
+	if mg.Fallbacks == nil {
+		mg.Fallbacks = map[assetType]string{}
+	}
+	mg.Fallbacks[asset] = source
+}
+
+// is_fallback is false for a nil manifest, for the commands only
+// interested in missing art.
+func (m *manifest) is_fallback(slug gameSlug, asset assetType) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mg := m.Games[slug]
+	return mg != nil && mg.Fallbacks[asset] != ""
+}
+
+func (m *manifest) clear_fallback(slug gameSlug, asset assetType) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mg := m.Games[slug]; mg != nil {
+		delete(mg.Fallbacks, asset)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+)
+
+const MATCH_CONFIDENCE_THRESHOLD = 0.6
+const MATCH_AMBIGUITY_MARGIN = 0.05
+
+// MATCH_VERIFIED_BONUS is enough for a verified game to win over an otherwise
+// equally close unverified one.
+const MATCH_VERIFIED_BONUS = MATCH_AMBIGUITY_MARGIN
+
+// ADDON_NAME matches titles of DLCs, soundtracks and other add-ons, which
+// SteamGridDB lists as games of their own.
+var ADDON_NAME = regexp.MustCompile(`(?i)\b(dlc|soundtrack|ost|season pass|expansion pass|artbook|art book|mod|demo|bonus content|upgrade pack|costume pack)\b`)
+
+type candidate struct {
+	Game       gameData `json:"game"`
+	Confidence float64  `json:"confidence"`
+	Strategy   string   `json:"strategy"`
+}
+
+// match_game returns false when the game must not be downloaded, either
+// because nothing matched or because it got quarantined for review, and
+// retry when the game was left for a retry at the end of the run.
+func (r *fetchRun) match_game(g game) (id int, ok bool, retry bool) {
+	if id, ok := r.cur.pinned(g.Slug); ok {
+		r.results.emit(&GameMatched{Slug: g.Slug, Name: g.Name, SgdbId: id, Strategy: "pin", Confidence: 1})
+		return id, true, false
+	}
+	if id, ok := r.checkpoint.matched(g.Slug); ok {
+		log.Debug("Using the match of the interrupted run", "game", g.Slug, "id", id)
+		return id, true, false
+	}
+	candidates, err := search_candidates(g, r.cur, r.stats, r.opts.Race)
+	if r.retry_later(g, err) {
+		return 0, false, true
+	}
+	if err != nil {
+		code := error_code(err)
+		log.Error("Error while retrieving SteamGridDB game ID", "game", g.Slug, "code", code, "err", err)
+		r.summary.add_failed(g, code, err.Error())
+		r.results.emit(&GameFailed{Slug: g.Slug, Name: g.Name, Code: code, Reason: err.Error()})
+		return 0, false, false
+	}
+
+	if r.opts.Interactive {
+		picked, ok := choose_candidate(r.in, g, candidates, r.cur, r.opts.Viewer, r.opts.Diverse)
+		if !ok {
+			r.summary.add_unmatched(g, E_SKIPPED, "skipped during interactive review")
+			r.checkpoint.record(g.Slug, STAGE_UNMATCHED, 0)
+			r.results.emit(&GameFailed{Slug: g.Slug, Name: g.Name, Code: E_SKIPPED, Reason: "skipped during interactive review"})
+			return 0, false, false
+		}
+		r.cur.pin(g.Slug, picked.Game.Id)
+		r.q.remove(g.Slug)
+		r.record_match(g, picked)
+		return picked.Game.Id, true, false
+	}
+
+	if len(candidates) == 0 {
+		log.Warn("No SteamGridDB game found", "game", g.Slug, "code", E_NO_MATCH)
+		r.summary.add_unmatched(g, E_NO_MATCH, "no game found")
+		r.checkpoint.record(g.Slug, STAGE_UNMATCHED, 0)
+		r.results.emit(&GameFailed{Slug: g.Slug, Name: g.Name, Code: E_NO_MATCH, Reason: "no game found"})
+		return 0, false, false
+	}
+	best := candidates[0]
+	if reason := match_doubt(candidates); reason != "" {
+		log.Warn("Match quarantined, run `review --batch` to decide", "game", g.Slug, "candidate", best.Game.Name, "confidence", fmt.Sprintf("%.0f%%", best.Confidence*100), "code", E_AMBIGUOUS, "reason", reason)
+		r.q.add(g.Slug, g.Name, reason, candidates)
+		r.summary.add_ambiguous(g, reason)
+		r.checkpoint.record(g.Slug, STAGE_UNMATCHED, 0)
+		r.results.emit(&GameFailed{Slug: g.Slug, Name: g.Name, Code: E_AMBIGUOUS, Reason: "quarantined: " + reason})
+		return 0, false, false
+	}
+	if r.opts.DryRun {
+		log.Info("Would match", "game", g.Slug, "candidate", best.Game.Name, "confidence", fmt.Sprintf("%.0f%%", best.Confidence*100), "strategy", best.Strategy)
+	}
+	r.q.remove(g.Slug)
+	r.record_match(g, best)
+	return best.Game.Id, true, false
+}
+
+func (r *fetchRun) record_match(g game, c candidate) {
+	r.stats.win(c.Strategy)
+	r.manifest.record_match(g.Slug, c)
+	r.checkpoint.record(g.Slug, STAGE_MATCHED, c.Game.Id)
+	r.results.emit(&GameMatched{Slug: g.Slug, Name: g.Name, SgdbId: c.Game.Id, Strategy: c.Strategy, Confidence: c.Confidence})
+}
+
+// search_candidates runs the match strategies in order, stopping at the first
+// one giving a confident and unambiguous match.
+func search_candidates(g game, cur *curation, stats *strategyStats, race bool) ([]candidate, error) {
+	if race {
+		return race_candidates(g, cur, stats)
+	}
+	var candidates []candidate
+	seen := map[int]bool{}
+	for _, strategy := range MATCH_STRATEGIES {
+		if !strategy.Applies(g) {
+			continue
+		}
+		stats.attempt(strategy.Name)
+		games, err := strategy.Search(context.Background(), g)
+		if err != nil {
+			return nil, err
+		}
+		candidates = merge_candidates(candidates, seen, rank_candidates(g, games, cur, strategy.Name))
+		if len(candidates) > 0 && match_doubt(candidates) == "" {
+			break
+		}
+	}
+	return candidates, nil
+}
+
+// race_candidates runs every applicable strategy at once and keeps the first
+// confident and unambiguous match, canceling the searches still running. It
+// costs more API calls than trying strategies in order but answers as fast as
+// the quickest strategy that succeeds.
+func race_candidates(g game, cur *curation, stats *strategyStats) ([]candidate, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type searchResult struct {
+		strategy string
+		games    []gameData
+		err      error
+	}
+	// buffered so the losing searches never block once the race is over
+	results := make(chan searchResult, len(MATCH_STRATEGIES))
+	running := 0
+	for _, strategy := range MATCH_STRATEGIES {
+		if !strategy.Applies(g) {
+			continue
+		}
+		stats.attempt(strategy.Name)
+		running++
+		go func() {
+			games, err := strategy.Search(ctx, g)
+			results <- searchResult{strategy.Name, games, err}
+		}()
+	}
+
+	var candidates []candidate
+	var firstErr error
+	seen := map[int]bool{}
+	for range running {
+		res := <-results
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		candidates = merge_candidates(candidates, seen, rank_candidates(g, res.games, cur, res.strategy))
+		if len(candidates) > 0 && match_doubt(candidates) == "" {
+			return candidates, nil
+		}
+	}
+	if len(candidates) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return candidates, nil
+}
+
+// merge_candidates adds the candidates not seen yet, keeping the list sorted.
+func merge_candidates(candidates []candidate, seen map[int]bool, ranked []candidate) []candidate {
+	for _, c := range ranked {
+		if !seen[c.Game.Id] {
+			seen[c.Game.Id] = true
+			candidates = append(candidates, c)
+		}
+	}
+	sort_candidates(candidates)
+	return candidates
+}
+
+func rank_candidates(g game, games []gameData, cur *curation, strategy string) []candidate {
+	var ranked []candidate
+	for _, data := range games {
+		if cur.is_blacklisted(g.Slug, data.Id) {
+			continue
+		}
+		if is_addon_of(data.Name, g.Name) {
+			log.Debug("Ignoring add-on of the game", "game", g.Slug, "candidate", data.Name)
+			continue
+		}
+		confidence := strategy_confidence(strategy, g, data)
+		if data.Verified {
+			confidence = min(1, confidence+MATCH_VERIFIED_BONUS)
+		}
+		ranked = append(ranked, candidate{Game: data, Confidence: confidence, Strategy: strategy})
+	}
+	sort_candidates(ranked)
+	return ranked
+}
+
+// is_addon_of guards against matching a base game with one of its add-ons,
+// whose name usually scores high.
+func is_addon_of(candidateName, gameName string) bool {
+	return ADDON_NAME.MatchString(candidateName) && !ADDON_NAME.MatchString(gameName)
+}
+
+func sort_candidates(candidates []candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+}
+
+// match_doubt explains why the best candidate cannot be trusted blindly, or
+// returns an empty string when it can.
+func match_doubt(candidates []candidate) string {
+	best := candidates[0]
+	if best.Confidence < MATCH_CONFIDENCE_THRESHOLD {
+		return "low confidence"
+	}
+	if len(candidates) > 1 && best.Confidence-candidates[1].Confidence < MATCH_AMBIGUITY_MARGIN {
+		return "ambiguous"
+	}
+	return ""
+}
+
+// match_confidence returns the Dice coefficient of the word sets of a and b,
+// so "the-witcher-3-wild-hunt-goty" still scores high against
+// "The Witcher 3: Wild Hunt". Both are transliterated first so "Pokémon"
+// and "Pokemon" are the same word.
+func match_confidence(a, b string) float64 {
+	wordsA, wordsB := word_set(a), word_set(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range wordsA {
+		if wordsB[w] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(wordsA)+len(wordsB))
+}
+
+func word_set(s string) map[string]bool {
+	words := strings.FieldsFunc(strings.ToLower(transliterate(s)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
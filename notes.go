@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// run_note attaches a note to the curation record of a game, e.g. to remember
+// why its cover is overridden.
+func run_note(args []string) {
+	fs := flag.NewFlagSet("note", flag.ExitOnError)
+	clear := fs.Bool("clear", false, "remove the notes of the game")
+	parse_flags(fs, args)
+	if fs.NArg() == 0 || (*clear && fs.NArg() != 1) {
+		log.Fatal("Usage: note <slug> <text>... | note --clear <slug>")
+	}
+	slug := gameSlug(fs.Arg(0))
+
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+	switch {
+	case *clear:
+		log.Info(fmt.Sprintf("%d notes removed", cur.clear_notes(slug)), "game", slug)
+	case fs.NArg() == 1:
+		for _, n := range cur.notes(slug) {
+			fmt.Printf("%s  %s\n", n.CreatedAt.Local().Format(time.DateTime), n.Text)
+		}
+		return
+	default:
+		text := strings.TrimSpace(strings.Join(fs.Args()[1:], " "))
+		if text == "" {
+			log.Fatal("Usage: note <slug> <text>... | note --clear <slug>")
+		}
+		cur.add_note(slug, text)
+		log.Info("Note added", "game", slug)
+	}
+	if err := cur.save(); err != nil {
+		log.Fatal("An error occurred while saving curation decisions", "err", err)
+	}
+}
+
+// run_info shows everything the tool knows of a game: what Lutris has, its
+// SteamGridDB match, its art, the curation decisions and notes about it.
+func run_info(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	add_lutris_version_flag(fs)
+	parse_flags(fs, args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: info <slug>")
+	}
+	slug := gameSlug(fs.Arg(0))
+
+	lutrisDirs, err := get_lutris_dir()
+	if err != nil {
+		log.Fatal("An error occurred while retrieving Lutris directories", "err", err)
+	}
+	db, err := connect_to_lutris_db(lutrisDirs.DbFilePath)
+	if err != nil {
+		log.Fatal("An error occurred while connecting to Lutris database", "err", err)
+	}
+	defer db.Close()
+	lutrisDirs = apply_lutris_compat(db, lutrisDirs, true)
+	games, err := load_library(db, librarySource{})
+	if err != nil {
+		log.Fatal("An error occurred while fetching installed games", "err", err)
+	}
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+	m, err := load_manifest()
+	if err != nil {
+		log.Fatal("An error occurred while loading the manifest", "err", err)
+	}
+	q, err := load_quarantine()
+	if err != nil {
+		log.Fatal("An error occurred while loading quarantined games", "err", err)
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+	fmt.Fprintf(out, "Slug\t%s\n", slug)
+	if selected := select_slugs(games, []gameSlug{slug}); len(selected) > 0 {
+		g := selected[0]
+		fmt.Fprintf(out, "Name\t%s\n", g.Name)
+		fmt.Fprintf(out, "Runner\t%s\n", or_none(string(g.Runner)))
+		fmt.Fprintf(out, "Service\t%s\n", or_none(strings.TrimSpace(string(g.Service)+" "+g.ServiceId)))
+		fmt.Fprintf(out, "Categories\t%s\n", or_none(strings.Join(g.Categories, ", ")))
+	} else {
+		fmt.Fprintf(out, "Name\tnot in the Lutris library\n")
+	}
+
+	match := "none yet"
+	if mg := m.Games[slug]; mg != nil {
+		match = fmt.Sprintf("%s #%d, %.0f%% via %s, %s", mg.Name, mg.SgdbId, mg.Confidence*100, mg.Strategy, mg.MatchedAt.Local().Format(time.DateTime))
+	}
+	if item, ok := q.item(slug); ok {
+		match = fmt.Sprintf("quarantined, %s, %d candidates", item.Reason, len(item.Candidates))
+	}
+	fmt.Fprintf(out, "Match\t%s\n", match)
+	if id, ok := cur.pinned(slug); ok {
+		fmt.Fprintf(out, "Pinned to\t#%d\n", id)
+	}
+	if blacklisted := cur.Blacklist[slug]; len(blacklisted) > 0 {
+		fmt.Fprintf(out, "Blacklisted\t%s\n", strings.Trim(fmt.Sprint(blacklisted), "[]"))
+	}
+
+	for _, kind := range ASSET_KINDS {
+		file := "missing"
+		if files := asset_files(lutrisDirs, kind, slug); len(files) > 0 {
+			file = files[0]
+		}
+		if m.is_fallback(slug, kind.Name) {
+			file += " (fallback from " + m.Games[slug].Fallbacks[kind.Name] + ")"
+		}
+		if url := cur.override_url(slug, kind.Name); url != "" {
+			file += " (overridden with " + url + ")"
+		}
+		fmt.Fprintf(out, "%s\t%s\n", strings.ToUpper(string(kind.Name[:1]))+string(kind.Name[1:]), file)
+	}
+	for i, n := range cur.notes(slug) {
+		title := ""
+		if i == 0 {
+			title = "Notes"
+		}
+		fmt.Fprintf(out, "%s\t%s  %s\n", title, n.CreatedAt.Local().Format(time.DateOnly), n.Text)
+	}
+}
+
+func or_none(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
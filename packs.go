@@ -0,0 +1,290 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+)
+
+const PACKS_DIR = "packs"
+const PACK_MANIFEST = "pack.json"
+const PACK_FORMAT = "lutris-cover-art-fetcher/art-pack"
+const PROVIDER_PACK = "pack"
+
+var PACK_NAME = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// artPackManifest is the pack.json at the root of an art pack zip, mapping
+// games to the images of the zip.
+type artPackManifest struct {
+	Format      string         `json:"format"`
+	Name        string         `json:"name"`
+	Author      string         `json:"author,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Games       []artPackEntry `json:"games"`
+}
+
+// artPackEntry matches a game by slug, store ID or name, whichever the pack
+// author knows, and gives the zip path of its image for each asset type.
+type artPackEntry struct {
+	Slug      gameSlug             `json:"slug,omitempty"`
+	Service   gameService          `json:"service,omitempty"`
+	ServiceId string               `json:"service_id,omitempty"`
+	Name      string               `json:"name,omitempty"`
+	Images    map[assetType]string `json:"images"`
+}
+
+type artPack struct {
+	manifest artPackManifest
+	zip      *zip.ReadCloser
+}
+
+// artPacks are the installed packs, applied in name order before any API is
+// asked, the first pack having an image for an asset winning.
+type artPacks []*artPack
+
+func run_install_pack(args []string) {
+	fs := flag.NewFlagSet("install-pack", flag.ExitOnError)
+	force := fs.Bool("force", false, "replace an installed pack with the same name")
+	parse_flags(fs, args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: install-pack [--force] <zip file or URL>")
+	}
+
+	data, err := read_pack_source(fs.Arg(0))
+	if err != nil {
+		log.Fatal("An error occurred while reading the art pack", "err", err)
+	}
+	m, err := validate_pack(data)
+	if err != nil {
+		log.Fatal("Invalid art pack", "err", err)
+	}
+	dir, err := packs_dir()
+	if err != nil {
+		log.Fatal("An error occurred while creating the packs directory", "err", err)
+	}
+	dest := filepath.Join(dir, m.Name+".zip")
+	if _, err := os.Stat(dest); err == nil && !*force {
+		log.Fatal("An art pack with this name is already installed, use --force to replace it", "pack", m.Name)
+	}
+	if err := install_pack_file(dir, dest, data); err != nil {
+		log.Fatal("An error occurred while installing the art pack", "err", err)
+	}
+	audit(AUDIT_WRITE, dest, "art pack from "+fs.Arg(0))
+	log.Info("Art pack installed", "pack", m.Name, "games", len(m.Games), "author", m.Author)
+}
+
+func run_list_packs(args []string) {
+	fs := flag.NewFlagSet("list-packs", flag.ExitOnError)
+	parse_flags(fs, args)
+
+	packs, err := load_packs()
+	if err != nil {
+		log.Fatal("An error occurred while loading art packs", "err", err)
+	}
+	defer packs.close()
+	if len(packs) == 0 {
+		log.Info("No art pack installed")
+		return
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "NAME\tGAMES\tAUTHOR\tDESCRIPTION")
+	for _, p := range packs {
+		fmt.Fprintf(out, "%s\t%d\t%s\t%s\n", p.manifest.Name, len(p.manifest.Games), p.manifest.Author, p.manifest.Description)
+	}
+	out.Flush()
+}
+
+func run_remove_pack(args []string) {
+	fs := flag.NewFlagSet("remove-pack", flag.ExitOnError)
+	parse_flags(fs, args)
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: remove-pack <name>")
+	}
+	dir, err := packs_dir()
+	if err != nil {
+		log.Fatal("An error occurred while creating the packs directory", "err", err)
+	}
+	if !PACK_NAME.MatchString(fs.Arg(0)) {
+		log.Fatal("No art pack with this name is installed", "pack", fs.Arg(0))
+	}
+	file := filepath.Join(dir, fs.Arg(0)+".zip")
+	if err := os.Remove(file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Fatal("No art pack with this name is installed", "pack", fs.Arg(0))
+		}
+		log.Fatal("An error occurred while removing the art pack", "err", err)
+	}
+	audit(AUDIT_DELETE, file, "art pack removed")
+	log.Info("Art pack removed, the art it gave stays until refreshed", "pack", fs.Arg(0))
+}
+
+// install_pack_file writes the pack next to its destination first, so a run
+// never opens a partly written zip.
+func install_pack_file(dir, dest string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func read_pack_source(source string) ([]byte, error) {
+	if is_http_url(source) {
+		return http_get(context.Background(), source, false)
+	}
+	return os.ReadFile(source)
+}
+
+// validate_pack checks the manifest and that every image it maps is in the
+// zip, in a format the asset type accepts, so installed packs never fail
+// halfway through a run.
+func validate_pack(data []byte) (artPackManifest, error) {
+	var m artPackManifest
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return m, err
+	}
+	if err := read_pack_manifest(r, &m); err != nil {
+		return m, err
+	}
+	if m.Format != PACK_FORMAT {
+		return m, fmt.Errorf("%s is not an art pack manifest, its format is %q", PACK_MANIFEST, m.Format)
+	}
+	if !PACK_NAME.MatchString(m.Name) {
+		return m, fmt.Errorf("pack name %q must be lowercase letters, digits, - and _", m.Name)
+	}
+	for i, e := range m.Games {
+		if e.Slug == "" && e.ServiceId == "" && e.Name == "" {
+			return m, fmt.Errorf("game %d has no slug, service_id nor name to match", i)
+		}
+		for asset, file := range e.Images {
+			kind, ok := asset_kind(asset)
+			if !ok {
+				return m, fmt.Errorf("game %d: unknown asset type %q", i, asset)
+			}
+			// opened like runs read it, which only takes clean paths
+			f, err := r.Open(file)
+			if err != nil {
+				return m, fmt.Errorf("game %d: %s cannot be read from the zip: %w", i, file, err)
+			}
+			f.Close()
+			if !kind.accepts_mime(mime_type_from_url(file)) {
+				return m, fmt.Errorf("game %d: %s is not a format Lutris reads for %ss", i, file, asset)
+			}
+		}
+	}
+	return m, nil
+}
+
+func read_pack_manifest(r *zip.Reader, m *artPackManifest) error {
+	f, err := r.Open(PACK_MANIFEST)
+	if err != nil {
+		return fmt.Errorf("no %s at the root of the zip: %w", PACK_MANIFEST, err)
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(m)
+}
+
+func packs_dir() (string, error) {
+	stateDir, err := get_state_dir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(stateDir, PACKS_DIR)
+	return dir, os.MkdirAll(dir, 0o755)
+}
+
+// load_packs opens the installed packs, which stay open until close.
+func load_packs() (artPacks, error) {
+	dir, err := packs_dir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.zip"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	var packs artPacks
+	for _, file := range files {
+		z, err := zip.OpenReader(file)
+		if err != nil {
+			packs.close()
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		p := &artPack{zip: z}
+		if err := read_pack_manifest(&z.Reader, &p.manifest); err != nil {
+			z.Close()
+			packs.close()
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		packs = append(packs, p)
+	}
+	return packs, nil
+}
+
+func (packs artPacks) close() {
+	for _, p := range packs {
+		p.zip.Close()
+	}
+}
+
+// image returns the image the packs have for the asset of the game, and the
+// pack:// URL naming it.
+func (packs artPacks) image(g game, kind assetKind) ([]byte, string, bool) {
+	for _, p := range packs {
+		idx := slices.IndexFunc(p.manifest.Games, func(e artPackEntry) bool { return e.Images[kind.Name] != "" && e.matches(g) })
+		if idx < 0 {
+			continue
+		}
+		file := p.manifest.Games[idx].Images[kind.Name]
+		data, err := read_zip_file(p.zip, file)
+		if err != nil {
+			log.Warn("Error while reading art pack image", "pack", p.manifest.Name, "file", file, "err", err)
+			continue
+		}
+		return data, "pack://" + p.manifest.Name + "/" + file, true
+	}
+	return nil, "", false
+}
+
+func (e artPackEntry) matches(g game) bool {
+	switch {
+	case e.Slug != "":
+		return e.Slug == g.Slug
+	case e.ServiceId != "":
+		return e.Service == g.Service && e.ServiceId == g.ServiceId
+	}
+	// the slug Lutris made of the name also matches games renamed since
+	return strings.EqualFold(normalize_name(e.Name), normalize_name(g.Name)) || lutris_slug(e.Name) == g.Slug
+}
+
+func read_zip_file(z *zip.ReadCloser, name string) ([]byte, error) {
+	f, err := z.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+)
+
+// planItem is a game along with the asset types it needs.
+type planItem struct {
+	Game  game
+	Kinds []assetKind
+}
+
+// assetPlan is the sparse set of (game, asset type) pairs a run works on,
+// computed once before anything is fetched. Skipped holds the pairs left out,
+// their asset being there already.
+type assetPlan struct {
+	Items   []planItem
+	Skipped []planItem
+}
+
+// plan_assets looks at every asset type of every game, a refresh needing them
+// all, a fetch only the missing ones and the fallbacks to replace.
+func plan_assets(dirs lutrisDirs, opts fetchOptions, m *manifest, games []game) assetPlan {
+	var p assetPlan
+	for _, g := range games {
+		needed, skipped := planItem{Game: g}, planItem{Game: g}
+		for _, kind := range opts.assets_for(g) {
+			if opts.Refresh || asset_needed(dirs, m, kind, g.Slug) {
+				needed.Kinds = append(needed.Kinds, kind)
+			} else {
+				skipped.Kinds = append(skipped.Kinds, kind)
+			}
+		}
+		if len(needed.Kinds) > 0 {
+			p.Items = append(p.Items, needed)
+		}
+		if len(skipped.Kinds) > 0 {
+			p.Skipped = append(p.Skipped, skipped)
+		}
+	}
+	return p
+}
+
+// pairs counts the (game, asset type) pairs to fetch.
+func (p assetPlan) pairs() int {
+	n := 0
+	for _, item := range p.Items {
+		n += len(item.Kinds)
+	}
+	return n
+}
+
+// prioritize puts the games users see first in their library first, the
+// most recently played ones, then the most played ones, so long initial runs
+// give them art first.
+func (p assetPlan) prioritize() assetPlan {
+	p.Items = slices.Clone(p.Items)
+	slices.SortStableFunc(p.Items, func(a, b planItem) int {
+		if c := cmp.Compare(b.Game.LastPlayed, a.Game.LastPlayed); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Game.Playtime, a.Game.Playtime)
+	})
+	return p
+}
+
+// only_kinds narrows asset type lists to the ones in only, keeping their
+// order.
+func only_kinds(kinds, only []assetKind) []assetKind {
+	return slices.DeleteFunc(slices.Clone(kinds), func(kind assetKind) bool {
+		return !slices.ContainsFunc(only, func(k assetKind) bool { return k.Name == kind.Name })
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const PROFILES_DIR = "profiles"
+
+const (
+	PROFILE_CPU   = "cpu"
+	PROFILE_MEM   = "mem"
+	PROFILE_TRACE = "trace"
+)
+
+// the stages of a run timed for the summary of --profile, in the order they
+// are printed
+const (
+	TIMING_API = "api"
+	// TIMING_RATE_LIMIT includes the backoff before retries.
+	TIMING_RATE_LIMIT = "rate limit"
+	TIMING_DOWNLOAD   = "download"
+	TIMING_IMAGE      = "image processing"
+	TIMING_DISK       = "disk"
+)
+
+var TIMINGS = []string{TIMING_API, TIMING_RATE_LIMIT, TIMING_DOWNLOAD, TIMING_IMAGE, TIMING_DISK}
+
+// stageTimings adds up the time spent in each stage of a run. Being cheap, it
+// is always kept, only printed with --profile.
+type stageTimings struct {
+	mu     sync.Mutex
+	total  map[string]time.Duration
+	counts map[string]int
+}
+
+var timings = &stageTimings{total: map[string]time.Duration{}, counts: map[string]int{}}
+
+// since is deferred with the start of the stage, as in
+// defer timings.since(TIMING_DISK, time.Now()).
+func (t *stageTimings) since(stage string, start time.Time) {
+	elapsed := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total[stage] += elapsed
+	t.counts[stage]++
+}
+
+// print shows the time each stage took, summed across the workers running
+// them concurrently, so a stage can add up to more than the run.
+func (t *stageTimings) print(elapsed time.Duration, workers int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	log.Info(fmt.Sprintf("Stage timings over %s, summed across %d workers", elapsed.Round(time.Millisecond), workers))
+	for _, stage := range TIMINGS {
+		n := t.counts[stage]
+		if n == 0 {
+			continue
+		}
+		log.Info(stage, "total", t.total[stage].Round(time.Millisecond), "count", n, "average", (t.total[stage] / time.Duration(n)).Round(100*time.Microsecond))
+	}
+}
+
+func parse_profile_kind(kind string) (string, error) {
+	switch kind {
+	case "", PROFILE_CPU, PROFILE_MEM, PROFILE_TRACE:
+		return kind, nil
+	}
+	return "", fmt.Errorf("unknown profile %q, expected cpu, mem or trace", kind)
+}
+
+// profiler writes a pprof profile or an execution trace of a run to the
+// state directory, for users to attach to reports of slow runs.
+type profiler struct {
+	kind    string
+	file    *os.File
+	started time.Time
+	workers int
+}
+
+// start_profiler returns nil when kind is empty, the methods of a nil
+// profiler doing nothing. A profile that cannot be started only warns, the
+// run being worth more than its profile.
+func start_profiler(kind string, workers int) *profiler {
+	if kind == "" {
+		return nil
+	}
+	p := &profiler{kind: kind, started: time.Now(), workers: workers}
+	file, err := create_profile_file(kind)
+	if err != nil {
+		log.Warn("Error while creating the profile, running without it", "err", err)
+		return p
+	}
+	switch kind {
+	case PROFILE_CPU:
+		err = pprof.StartCPUProfile(file)
+	case PROFILE_TRACE:
+		err = trace.Start(file)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		log.Warn("Error while starting the profile, running without it", "err", err)
+		return p
+	}
+	p.file = file
+	return p
+}
+
+func create_profile_file(kind string) (*os.File, error) {
+	stateDir, err := get_state_dir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(stateDir, PROFILES_DIR)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ext := ".pprof"
+	if kind == PROFILE_TRACE {
+		ext = ".trace"
+	}
+	return os.Create(filepath.Join(dir, "run-"+time.Now().Format("20060102-150405")+"-"+kind+ext))
+}
+
+// stop writes the profile and prints the stage timings.
+func (p *profiler) stop() {
+	if p == nil {
+		return
+	}
+	timings.print(time.Since(p.started), p.workers)
+	if p.file == nil {
+		return
+	}
+	var err error
+	switch p.kind {
+	case PROFILE_CPU:
+		pprof.StopCPUProfile()
+	case PROFILE_TRACE:
+		trace.Stop()
+	case PROFILE_MEM:
+		// allocs rather than heap, to see what the whole run allocated
+		// and not only what is still live at its end
+		runtime.GC()
+		err = pprof.Lookup("allocs").WriteTo(p.file, 0)
+	}
+	if closeErr := p.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Error("Error while writing the profile", "file", p.file.Name(), "err", err)
+		return
+	}
+	log.Info("Profile written", "file", p.file.Name())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const PROVENANCE_XATTR = "xattr"
+const PROVENANCE_SIDECAR = "sidecar"
+const PROVENANCE_SIDECAR_SUFFIX = ".source.json"
+
+const PROVIDER_STEAMGRIDDB = "steamgriddb"
+const PROVIDER_IGDB = "igdb"
+const PROVIDER_OVERRIDE = "override"
+
+// XATTR_ORIGIN_URL is the freedesktop.org attribute for where a file was
+// downloaded from, which file managers already show.
+const XATTR_ORIGIN_URL = "user.xdg.origin.url"
+const XATTR_PROVIDER = "user.lutris-cover-art-fetcher.provider"
+const XATTR_IMAGE_ID = "user.lutris-cover-art-fetcher.image_id"
+const XATTR_FETCHED_AT = "user.lutris-cover-art-fetcher.fetched_at"
+
+// assetSource tells where an asset file came from, so users and scripts can
+// tell without the manifest.
+type assetSource struct {
+	Provider  string    `json:"provider"`
+	Url       string    `json:"url"`
+	ImageId   int       `json:"image_id,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+func parse_provenance(mode string) (string, error) {
+	switch mode {
+	case "", PROVENANCE_XATTR, PROVENANCE_SIDECAR:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown provenance mode %q, expected xattr or sidecar", mode)
+}
+
+// record_provenance labels the file with its source, in extended attributes
+// or, where the filesystem has none, in a sidecar file next to it. Without a
+// mode it removes the labels of the file it replaced. Errors are only logged,
+// the asset being written anyway.
+func record_provenance(mode, file string, src assetSource) {
+	var err error
+	switch mode {
+	case "":
+		err = clear_provenance(file)
+	case PROVENANCE_XATTR:
+		err = write_provenance_xattrs(file, src)
+		if errors.Is(err, errors.ErrUnsupported) {
+			log.Debug("Extended attributes not supported, using a sidecar file", "file", file)
+			err = write_provenance_sidecar(file, src)
+		} else if err == nil {
+			err = remove_sidecar(file)
+		}
+	case PROVENANCE_SIDECAR:
+		err = write_provenance_sidecar(file, src)
+		if err == nil {
+			err = clear_provenance_xattrs(file)
+		}
+	}
+	if err != nil {
+		log.Warn("Error while recording where the asset came from", "file", file, "err", err)
+	}
+}
+
+func write_provenance_xattrs(file string, src assetSource) error {
+	attrs := [][2]string{
+		{XATTR_ORIGIN_URL, src.Url},
+		{XATTR_PROVIDER, src.Provider},
+		{XATTR_FETCHED_AT, src.FetchedAt.Format(time.RFC3339)},
+	}
+	if src.ImageId != 0 {
+		attrs = append(attrs, [2]string{XATTR_IMAGE_ID, strconv.Itoa(src.ImageId)})
+	}
+	for _, attr := range attrs {
+		if err := set_xattr(file, attr[0], attr[1]); err != nil {
+			return err
+		}
+	}
+	if src.ImageId == 0 {
+		return remove_xattr(file, XATTR_IMAGE_ID)
+	}
+	return nil
+}
+
+func write_provenance_sidecar(file string, src assetSource) error {
+	data, err := json.MarshalIndent(src, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file+PROVENANCE_SIDECAR_SUFFIX, append(data, '\n'), 0o644)
+}
+
+func clear_provenance(file string) error {
+	if err := clear_provenance_xattrs(file); err != nil {
+		return err
+	}
+	return remove_sidecar(file)
+}
+
+func clear_provenance_xattrs(file string) error {
+	for _, name := range []string{XATTR_ORIGIN_URL, XATTR_PROVIDER, XATTR_IMAGE_ID, XATTR_FETCHED_AT} {
+		if err := remove_xattr(file, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func remove_sidecar(file string) error {
+	err := os.Remove(file + PROVENANCE_SIDECAR_SUFFIX)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func set_xattr(file, name, value string) error {
+	err := syscall.Setxattr(file, name, []byte(value), 0)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return errors.ErrUnsupported
+	}
+	return err
+}
+
+// remove_xattr ignores missing attributes.
+func remove_xattr(file, name string) error {
+	err := syscall.Removexattr(file, name)
+	if errors.Is(err, syscall.ENODATA) || errors.Is(err, syscall.ENOTSUP) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// extended attributes are only used on Linux, other systems get sidecars
+func set_xattr(file, name, value string) error {
+	return errors.ErrUnsupported
+}
+
+func remove_xattr(file, name string) error {
+	return nil
+}
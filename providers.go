@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+// lazy is a provider set up on first use only, so the commands and runs
+// never needing it do not wait for it: art packs are only opened and the
+// Steam library only scanned once there is art to look for.
+type lazy[T any] struct {
+	name string
+	once func() (T, error)
+}
+
+func new_lazy[T any](name string, load func() (T, error)) *lazy[T] {
+	return &lazy[T]{name: name, once: sync.OnceValues(load)}
+}
+
+// start sets the provider up in the background, to be ready by its first
+// use. Providers started together are set up in parallel.
+func (l *lazy[T]) start() {
+	go l.once()
+}
+
+// get waits for the provider, exiting when it could not be set up.
+func (l *lazy[T]) get() T {
+	v, err := l.once()
+	if err != nil {
+		log.Fatal("An error occurred while loading "+l.name, "err", err)
+	}
+	return v
+}
+
+// ready returns a provider already set up, for the optional ones.
+func ready[T any](name string, v T) *lazy[T] {
+	return new_lazy(name, func() (T, error) { return v, nil })
+}
+
+// start_providers sets up every provider the run may use at once, when it
+// has something to fetch. The IGDB token is requested ahead too, a failure
+// being retried on first use.
+func (r *fetchRun) start_providers() {
+	r.packs.start()
+	r.steam.start()
+	if r.opts.ScreenshotBanners {
+		go igdb.access_token(context.Background())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const QUARANTINE_FILE = "quarantine.json"
+
+// quarantine holds games whose best match was not confident or distinct
+// enough, waiting for a decision in `review --batch`.
+type quarantine struct {
+	mu sync.Mutex
+
+	Items map[gameSlug]*quarantineItem `json:"items"`
+}
+
+type quarantineItem struct {
+	Slug       gameSlug    `json:"slug"`
+	Term       string      `json:"term"`
+	Reason     string      `json:"reason"`
+	Candidates []candidate `json:"candidates"`
+	QueuedAt   time.Time   `json:"queued_at"`
+}
+
+func load_quarantine() (*quarantine, error) {
+	q := &quarantine{}
+	err := read_state_file(QUARANTINE_FILE, q)
+	if q.Items == nil {
+		q.Items = map[gameSlug]*quarantineItem{}
+	}
+	return q, err
+}
+
+func (q *quarantine) save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return write_state_file(QUARANTINE_FILE, q)
+}
+
+func (q *quarantine) add(slug gameSlug, term, reason string, candidates []candidate) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Items[slug] = &quarantineItem{
+		Slug:       slug,
+		Term:       term,
+		Reason:     reason,
+		Candidates: candidates,
+		QueuedAt:   time.Now(),
+	}
+}
+
+func (q *quarantine) remove(slug gameSlug) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.Items, slug)
+}
+
+// item returns a copy of the quarantined game, safe to read while the
+// quarantine keeps changing.
+func (q *quarantine) item(slug gameSlug) (quarantineItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.Items[slug]
+	if !ok {
+		return quarantineItem{}, false
+	}
+	copied := *item
+	copied.Candidates = slices.Clone(item.Candidates)
+	return copied, true
+}
+
+// drop_candidate returns how many candidates the game has left.
+func (q *quarantine) drop_candidate(slug gameSlug, gameId int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.Items[slug]
+	if !ok {
+		return 0
+	}
+	item.Candidates = slices.DeleteFunc(item.Candidates, func(c candidate) bool { return c.Game.Id == gameId })
+	return len(item.Candidates)
+}
+
+// listing sorts the quarantined games by name for the user, grouping them
+// when by is set, in a single unnamed group otherwise.
+func (q *quarantine) listing(by string) []listingGroup[quarantineListItem] {
+	gameOf := library_games()
+	var items []quarantineListItem
+	for _, item := range q.sorted_items() {
+		items = append(items, quarantineListItem{item, gameOf(item.Slug)})
+	}
+	sort_by_name(items, func(i quarantineListItem) string { return i.Game.Name }, func(i quarantineListItem) string { return string(i.Slug) })
+	if by == "" {
+		return []listingGroup[quarantineListItem]{{Items: items}}
+	}
+	return group_listing(items, func(i quarantineListItem) game { return i.Game }, by)
+}
+
+// quarantineListItem is a quarantined game along with what the library knows
+// of it.
+type quarantineListItem struct {
+	*quarantineItem
+	Game game
+}
+
+func (q *quarantine) sorted_items() []*quarantineItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]*quarantineItem, 0, len(q.Items))
+	for _, item := range q.Items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Slug < items[j].Slug
+	})
+	return items
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func run_review(args []string) {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	batch := fs.Bool("batch", false, "step through quarantined games one per line")
+	viewer := fs.String("viewer", "", "image viewer command used to preview candidates, e.g. feh")
+	diverse := fs.Bool("diverse", false, "preview images from different uploaders and styles first")
+	resume := fs.Bool("resume", false, "continue the last batch review where it stopped")
+	timeLimit := fs.Duration("time-limit", 0, "stop reviewing after this long, e.g. 30m, to --resume later")
+	groupBy := fs.String("group-by", "", "review the games grouped by runner, service or category")
+	add_api_flag(fs)
+	parse_flags(fs, args)
+	if !*batch {
+		log.Fatal("Only batch review is supported for now, run `review --batch`")
+	}
+	if err := check_group_by(*groupBy); err != nil {
+		log.Fatal("Invalid --group-by value", "err", err)
+	}
+	if *viewer != "" {
+		SGDB_API_KEY = os.Getenv("SGDB_API_KEY")
+		if SGDB_API_KEY == "" {
+			log.Fatal("Please set the SGDB_API_KEY environment variable to preview candidates")
+		}
+	}
+
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+	q, err := load_quarantine()
+	if err != nil {
+		log.Fatal("An error occurred while loading quarantined games", "err", err)
+	}
+	stats, err := load_strategy_stats()
+	if err != nil {
+		log.Fatal("An error occurred while loading match statistics", "err", err)
+	}
+	m, err := load_manifest()
+	if err != nil {
+		log.Fatal("An error occurred while loading the manifest", "err", err)
+	}
+	cursor := &reviewCursor{}
+	if *resume {
+		if err := read_state_file(REVIEW_CURSOR_FILE, cursor); err != nil {
+			log.Fatal("An error occurred while loading the review session", "err", err)
+		}
+	}
+	session := &reviewSession{cur: cur, q: q, stats: stats, manifest: m, viewer: *viewer, diverse: *diverse, cursor: cursor}
+	// games in several categories are reviewed once, in the first of them
+	var listed []*quarantineItem
+	groupOf := map[gameSlug]string{}
+	for _, group := range q.listing(*groupBy) {
+		for _, item := range group.Items {
+			if _, seen := groupOf[item.Slug]; !seen {
+				groupOf[item.Slug] = group.Name
+				listed = append(listed, item.quarantineItem)
+			}
+		}
+	}
+	items := cursor.pending(listed)
+	if len(items) == 0 {
+		log.Info("No quarantined games to review")
+		remove_state_file(REVIEW_CURSOR_FILE)
+		return
+	}
+	if len(cursor.Reviewed) > 0 {
+		log.Info(fmt.Sprintf("Resuming review, %d games reviewed earlier", len(cursor.Reviewed)))
+	}
+
+	start := time.Now()
+	in := bufio.NewScanner(os.Stdin)
+	group := ""
+	for i, item := range items {
+		if *timeLimit > 0 && time.Since(start) >= *timeLimit {
+			log.Info("Time limit reached, run `review --batch --resume` to continue")
+			return
+		}
+		if *groupBy != "" && (i == 0 || groupOf[item.Slug] != group) {
+			group = groupOf[item.Slug]
+			log.Info("Reviewing", *groupBy, group)
+		}
+		if !session.review_item(in, item, i+1, len(items)) {
+			log.Info("Review stopped, run `review --batch --resume` to continue")
+			return
+		}
+		cursor.reviewed(item.Slug)
+		session.save_cursor()
+	}
+	remove_state_file(REVIEW_CURSOR_FILE)
+}
+
+const REVIEW_CURSOR_FILE = "review-session.json"
+
+// reviewCursor is where a batch review stopped. Games are tracked by slug
+// rather than position so resuming still works after games were added to or
+// left the quarantine in between.
+type reviewCursor struct {
+	// Reviewed holds the games decided or skipped.
+	Reviewed []gameSlug `json:"reviewed"`
+	// Current and Candidate are the game and SteamGridDB ID shown when the
+	// review stopped.
+	Current   gameSlug  `json:"current,omitempty"`
+	Candidate int       `json:"candidate,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// pending puts the game the review stopped on first.
+func (c *reviewCursor) pending(items []*quarantineItem) []*quarantineItem {
+	var pending []*quarantineItem
+	for _, item := range items {
+		if slices.Contains(c.Reviewed, item.Slug) {
+			continue
+		}
+		if item.Slug == c.Current {
+			pending = append([]*quarantineItem{item}, pending...)
+		} else {
+			pending = append(pending, item)
+		}
+	}
+	return pending
+}
+
+func (c *reviewCursor) reviewed(slug gameSlug) {
+	c.Reviewed = append(c.Reviewed, slug)
+	c.Current = ""
+	c.Candidate = 0
+}
+
+// start_index returns the candidate to show first, the one the review
+// stopped on when resuming.
+func (c *reviewCursor) start_index(item *quarantineItem) int {
+	if item.Slug != c.Current {
+		return 0
+	}
+	return max(0, slices.IndexFunc(item.Candidates, func(cand candidate) bool { return cand.Game.Id == c.Candidate }))
+}
+
+type reviewSession struct {
+	cur      *curation
+	q        *quarantine
+	stats    *strategyStats
+	manifest *manifest
+	viewer   string
+	diverse  bool
+	cursor   *reviewCursor
+}
+
+// review_item returns false once the user wants to stop reviewing.
+func (s *reviewSession) review_item(in *bufio.Scanner, item *quarantineItem, pos, total int) bool {
+	keys := "[a]ccept [n]ext [s]kip [b]lacklist [q]uit"
+	if s.viewer != "" {
+		keys = "[a]ccept [n]ext [s]kip [b]lacklist [v]iew [q]uit"
+	}
+	for _, n := range s.cur.notes(item.Slug) {
+		fmt.Printf("  note: %s\n", n.Text)
+	}
+	idx := s.cursor.start_index(item)
+	for len(item.Candidates) > 0 {
+		c := item.Candidates[idx]
+		s.cursor.Current, s.cursor.Candidate = item.Slug, c.Game.Id
+		s.save_cursor()
+		fmt.Printf("[%d/%d] %s → %s (#%d, %.0f%%%s) candidate %d/%d  %s > ",
+			pos, total, item.Slug, c.Game.Name, c.Game.Id, c.Confidence*100, verified_mark(c.Game), idx+1, len(item.Candidates), keys)
+		if !in.Scan() {
+			fmt.Println()
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(in.Text())) {
+		case "a":
+			s.accept(item.Slug, c)
+			return true
+		case "n":
+			idx = (idx + 1) % len(item.Candidates)
+		case "s":
+			return true
+		case "b":
+			s.cur.blacklist(item.Slug, c.Game.Id)
+			s.q.drop_candidate(item.Slug, c.Game.Id)
+			if idx >= len(item.Candidates) {
+				idx = 0
+			}
+			s.save()
+		case "v":
+			if s.viewer == "" {
+				continue
+			}
+			if err := preview_game_grids(s.viewer, c.Game.Id, s.diverse); err != nil {
+				log.Error("Error while previewing grids", "game", item.Slug, "err", err)
+			}
+		case "q":
+			return false
+		}
+	}
+	log.Warn("Every candidate was blacklisted, the next run will search again", "game", item.Slug)
+	s.q.remove(item.Slug)
+	s.save()
+	return true
+}
+
+func (s *reviewSession) accept(slug gameSlug, c candidate) {
+	s.cur.pin(slug, c.Game.Id)
+	s.q.remove(slug)
+	s.stats.win(c.Strategy)
+	s.manifest.record_match(slug, c)
+	s.save()
+}
+
+func (s *reviewSession) save_cursor() {
+	s.cursor.UpdatedAt = time.Now()
+	if err := write_state_file(REVIEW_CURSOR_FILE, s.cursor); err != nil {
+		log.Error("Error while saving the review session", "err", err)
+	}
+}
+
+func (s *reviewSession) save() {
+	if err := s.cur.save(); err != nil {
+		log.Error("Error while saving curation decisions", "err", err)
+	}
+	if err := s.q.save(); err != nil {
+		log.Error("Error while saving quarantined games", "err", err)
+	}
+	if err := s.stats.save(); err != nil {
+		log.Error("Error while saving match statistics", "err", err)
+	}
+	if err := s.manifest.save(); err != nil {
+		log.Error("Error while saving the manifest", "err", err)
+	}
+}
@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const DEFAULT_WORKERS = 4
+
+type fetchOptions struct {
+	Interactive bool
+	DryRun      bool
+	Viewer      string
+	Diverse     bool
+	Assets      []assetKind
+	// RunnerAssets replaces Assets for the games of some runners.
+	RunnerAssets map[gameRunner][]assetKind
+	Workers      int
+	Processing   imageProcessing
+	Race         bool
+	Events       string
+	// MarkCustom sets the has_custom_* flags of the games in the Lutris
+	// database, unless NoDbWrites is set.
+	MarkCustom bool
+	NoDbWrites bool
+	// Refresh downloads the assets again even when they exist.
+	Refresh bool
+	// ScreenshotBanners makes banners out of IGDB screenshots for the games
+	// SteamGridDB has neither a banner nor a hero for.
+	ScreenshotBanners bool
+	// Provenance labels the asset files with their source, in extended
+	// attributes or sidecar files.
+	Provenance string
+	// NoPacks leaves the installed art packs out of the run.
+	NoPacks bool
+	// SteamArt copies the art of a local Steam install for the games it has.
+	SteamArt bool
+	// RequiredAssets are the asset types a game must get for any of its new
+	// art to be committed, every one it needs with RequireAll.
+	RequiredAssets []assetKind
+	RequireAll     bool
+	// Where selects the games to process, every one when nil.
+	Where *gameFilter
+	// CheckpointEvery saves the progress of the run every so many games, for
+	// Resume to continue it once interrupted. Only one-shot fetch runs set
+	// it, watch and the helper running until stopped.
+	CheckpointEvery int
+	Resume          bool
+	// Catalog gives the art of the launchers and tools installed like games.
+	Catalog toolCatalog
+	// Profile is the pprof profile to write of the run, cpu, mem or trace.
+	Profile string
+	// Results consumes the results of the run as they come, in place of the
+	// stream of Events.
+	Results func(results <-chan Result)
+}
+
+// fetchRun is shared by the workers, curation, quarantine and summary doing
+// their own locking.
+type fetchRun struct {
+	opts     fetchOptions
+	dirs     lutrisDirs
+	cur      *curation
+	q        *quarantine
+	stats    *strategyStats
+	manifest *manifest
+	summary  *runSummary
+	results  *resultStream
+	dbWrites *dbWrites
+	// checkpoint is nil unless the run saves its progress.
+	checkpoint *checkpoint
+	// profiler is nil unless the run is profiled.
+	profiler *profiler
+	packs    *lazy[artPacks]
+	steam    *lazy[*steamLibrary]
+	cpu      *cpuPool
+	in       *bufio.Scanner
+
+	// retries are the games given a second chance at the end of the run,
+	// after a malformed API response.
+	mu       sync.Mutex
+	retries  []game
+	retrying bool
+	// commits are the games whose art is committed as a whole, by slug.
+	commits map[gameSlug]*gameCommit
+}
+
+// fetchFlags holds the flags shared by every command going through the fetch
+// pipeline.
+type fetchFlags struct {
+	fs          *flag.FlagSet
+	opts        fetchOptions
+	assets      string
+	onlyMissing string
+	required    string
+	transcode   string
+	where       string
+	debug       bool
+}
+
+func add_fetch_flags(fs *flag.FlagSet) *fetchFlags {
+	f := &fetchFlags{fs: fs}
+	fs.StringVar(&f.assets, "assets", DEFAULT_ASSETS, "comma-separated asset types to fetch among cover, banner, icon, hero and logo")
+	fs.StringVar(&f.onlyMissing, "only-missing", "", "comma-separated asset types to fetch for the games missing them, leaving the other types alone, e.g. banner")
+	fs.StringVar(&f.where, "where", "", `only process the games matching this expression, e.g. 'runner == "wine" && !hidden && lastplayed > 2024-01-01'`)
+	fs.IntVar(&f.opts.Workers, "workers", DEFAULT_WORKERS, "number of games processed concurrently")
+	fs.BoolVar(&f.opts.Processing.Resize, "resize", false, "resize images to the size Lutris displays them at")
+	fs.StringVar(&f.transcode, "transcode", "", "re-encode images to jpg or png when the asset type allows it")
+	fs.StringVar(&f.required, "required-assets", "", "comma-separated asset types, or all, a game must get for any of its new art to be moved into place, e.g. cover,banner")
+	fs.StringVar(&f.opts.Events, "events", "", "stream match and download results to stdout, json being the only format")
+	fs.BoolVar(&f.opts.Race, "race", false, "run all match strategies at once and keep the first confident match, using more API calls for a faster answer")
+	add_api_flag(fs)
+	add_lutris_version_flag(fs)
+	fs.BoolVar(&f.opts.MarkCustom, "mark-custom", false, "flag the games as having custom art in the Lutris database, in one transaction at the end of the run")
+	fs.BoolVar(&f.opts.NoDbWrites, "no-db-writes", false, "never write to the Lutris database, whatever the other flags")
+	fs.BoolVar(&f.opts.ScreenshotBanners, "screenshot-banners", false, "when SteamGridDB has no banner nor hero for a game, crop its first IGDB screenshot into a fallback banner")
+	fs.StringVar(&f.opts.Provenance, "provenance", "", "label asset files with where they came from, in xattr (extended attributes, sidecar files where unsupported) or sidecar files")
+	fs.BoolVar(&f.opts.NoPacks, "no-packs", false, "ignore the installed art packs, getting every image from the APIs")
+	fs.BoolVar(&f.opts.SteamArt, "steam-art", false, "copy the covers, heroes and logos a local Steam install downloaded for the games it has, before asking any API")
+	fs.StringVar(&f.opts.Profile, "profile", "", "write a cpu, mem or trace profile of the run to the state directory and show where its time went")
+	fs.BoolVar(&f.debug, "debug", false, "log debug messages and save malformed API responses to the debug cache directory")
+	return f
+}
+
+func (f *fetchFlags) options() fetchOptions {
+	opts := f.opts
+	if f.debug {
+		if err := enable_debug(); err != nil {
+			log.Fatal("An error occurred while creating the debug directory", "err", err)
+		}
+	}
+	kinds, err := parse_asset_kinds(f.assets)
+	if err != nil {
+		log.Fatal("Invalid --assets value", "err", err)
+	}
+	opts.Assets = kinds
+	// an explicit --assets applies to every game
+	if !flag_set(f.fs, "assets") {
+		opts.RunnerAssets = runner_assets()
+	}
+	opts.Catalog = tool_catalog()
+	if f.onlyMissing != "" {
+		only, err := parse_asset_kinds(f.onlyMissing)
+		if err != nil {
+			log.Fatal("Invalid --only-missing value", "err", err)
+		}
+		opts.Assets = only_kinds(opts.Assets, only)
+		for runner, kinds := range opts.RunnerAssets {
+			opts.RunnerAssets[runner] = only_kinds(kinds, only)
+		}
+		if len(opts.all_assets()) == 0 {
+			log.Fatal("Invalid --only-missing value", "err", "none of these asset types is selected by --assets or runner_assets")
+		}
+	}
+	opts.RequiredAssets, opts.RequireAll, err = parse_required_assets(f.required)
+	if err != nil {
+		log.Fatal("Invalid --required-assets value", "err", err)
+	}
+	opts.Processing.Transcode, err = parse_transcode_format(f.transcode)
+	if err != nil {
+		log.Fatal("Invalid --transcode value", "err", err)
+	}
+	opts.Where, err = parse_game_filter(f.where)
+	if err != nil {
+		log.Fatal("Invalid --where value", "err", err)
+	}
+	opts.Provenance, err = parse_provenance(opts.Provenance)
+	if err != nil {
+		log.Fatal("Invalid --provenance value", "err", err)
+	}
+	opts.Profile, err = parse_profile_kind(opts.Profile)
+	if err != nil {
+		log.Fatal("Invalid --profile value", "err", err)
+	}
+	opts.Events, err = parse_events_format(opts.Events)
+	if err != nil {
+		log.Fatal("Invalid --events value", "err", err)
+	}
+	if opts.Events != "" && opts.Interactive {
+		log.Fatal("Invalid --events value", "err", "events and interactive prompts would both be written to stdout")
+	}
+	// IGDB is optional for matching, only screenshot banners requiring it
+	if err := load_igdb_credentials(); err != nil && opts.ScreenshotBanners {
+		log.Fatal("Cannot make banners out of screenshots", "err", err)
+	}
+	if opts.Interactive || opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	return opts
+}
+
+func flag_set(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func runner_assets() map[gameRunner][]assetKind {
+	var lists map[gameRunner]string
+	if value := setting_value("runner_assets"); value != "" {
+		if err := json.Unmarshal([]byte(value), &lists); err != nil {
+			log.Fatal("Invalid runner_assets in the configuration", "err", err)
+		}
+	}
+	assets := map[gameRunner][]assetKind{}
+	for runner, list := range lists {
+		kinds, err := parse_asset_kinds(list)
+		if err != nil {
+			log.Fatal("Invalid runner_assets in the configuration", "runner", runner, "err", err)
+		}
+		assets[runner] = kinds
+	}
+	return assets
+}
+
+// assets_for plans which asset types to fetch for a game.
+func (o fetchOptions) assets_for(g game) []assetKind {
+	if kinds, ok := o.RunnerAssets[g.Runner]; ok {
+		return kinds
+	}
+	return o.Assets
+}
+
+// all_assets lists every asset type some game may get, in ASSET_KINDS order.
+func (o fetchOptions) all_assets() []assetKind {
+	var kinds []assetKind
+	for _, kind := range ASSET_KINDS {
+		selected := slices.ContainsFunc(o.Assets, func(k assetKind) bool { return k.Name == kind.Name })
+		for _, runnerKinds := range o.RunnerAssets {
+			selected = selected || slices.ContainsFunc(runnerKinds, func(k assetKind) bool { return k.Name == kind.Name })
+		}
+		if selected {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+func (o fetchOptions) db_writes() bool {
+	return o.MarkCustom && !o.NoDbWrites && !o.DryRun
+}
+
+func new_fetch_run(opts fetchOptions, dirs lutrisDirs) *fetchRun {
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+	q, err := load_quarantine()
+	if err != nil {
+		log.Fatal("An error occurred while loading quarantined games", "err", err)
+	}
+	stats, err := load_strategy_stats()
+	if err != nil {
+		log.Fatal("An error occurred while loading match statistics", "err", err)
+	}
+	m, err := load_manifest()
+	if err != nil {
+		log.Fatal("An error occurred while loading the manifest", "err", err)
+	}
+	r := &fetchRun{
+		opts:     opts,
+		dirs:     dirs,
+		cur:      cur,
+		q:        q,
+		stats:    stats,
+		manifest: m,
+		summary:  new_run_summary(),
+		cpu:      new_cpu_pool(runtime.NumCPU()),
+		in:       bufio.NewScanner(os.Stdin),
+	}
+	r.packs = ready[artPacks]("art packs", nil)
+	if !opts.NoPacks {
+		r.packs = new_lazy("art packs", load_packs)
+	}
+	r.steam = ready[*steamLibrary]("the Steam library", nil)
+	if opts.SteamArt {
+		r.steam = new_lazy("the Steam library", func() (*steamLibrary, error) {
+			steam, err := load_steam_library()
+			if err == nil && steam == nil {
+				log.Warn("Ignoring --steam-art, no Steam install found")
+			}
+			return steam, err
+		})
+	}
+	if opts.CheckpointEvery > 0 && !opts.DryRun {
+		r.checkpoint = load_checkpoint(opts.Resume, opts.CheckpointEvery)
+	}
+	r.profiler = start_profiler(opts.Profile, opts.Workers)
+	if opts.Results != nil {
+		r.results = new_result_stream(opts.Results)
+	} else if opts.Events == EVENTS_FORMAT_JSON {
+		r.results = new_json_result_stream(os.Stdout)
+	}
+	if opts.db_writes() {
+		if dirs.Compat.CustomArtFlags {
+			r.dbWrites = new_db_writes()
+		} else {
+			log.Warn("Ignoring --mark-custom, the installed Lutris version has no custom art flags")
+		}
+	}
+	return r
+}
+
+// process_plan returns once every image of the plan is written, processing
+// included.
+func (r *fetchRun) process_plan(p assetPlan) {
+	defer r.cpu.wait()
+	r.start_providers()
+	for _, item := range p.Skipped {
+		for _, kind := range item.Kinds {
+			r.summary.count(kind, OUTCOME_SKIPPED)
+		}
+	}
+	r.run_workers(p.prioritize().Items)
+	if len(r.retries) == 0 {
+		return
+	}
+	log.Info(fmt.Sprintf("Retrying %d games after malformed API responses", len(r.retries)))
+	// planned again, their skipped assets being counted already
+	retries := plan_assets(r.dirs, r.opts, r.manifest, r.retries)
+	r.retries, r.retrying = nil, true
+	r.run_workers(retries.Items)
+	r.retrying = false
+}
+
+// close_results ends the event stream with the failures of the run by code.
+func (r *fetchRun) close_results() {
+	r.results.emit(&RunFinished{Codes: r.summary.codes()})
+	r.results.close()
+}
+
+// offload hands the writing of a downloaded image to the CPU pool when it
+// gets processed, so network workers go on with the next downloads instead
+// of decoding and encoding.
+func (r *fetchRun) offload(g game, proc imageProcessing, write func()) {
+	if !proc.enabled() {
+		write()
+		return
+	}
+	if c := r.game_commit(g); c != nil {
+		c.pending.Add(1)
+		r.cpu.submit(func() {
+			defer c.pending.Done()
+			write()
+		})
+		return
+	}
+	r.cpu.submit(write)
+}
+
+// retry_later marks the game for a retry at the end of the run when err is a
+// malformed API response, unless it is the retry already failing.
+func (r *fetchRun) retry_later(g game, err error) bool {
+	if r.retrying || !is_malformed_response(err) {
+		return false
+	}
+	log.Warn("Malformed API response, the game will be retried at the end of the run", "game", g.Slug, "code", E_MALFORMED_RESPONSE, "err", err)
+	r.mu.Lock()
+	r.retries = append(r.retries, g)
+	r.mu.Unlock()
+	return true
+}
+
+func (r *fetchRun) run_workers(items []planItem) {
+	jobs := make(chan planItem)
+	var wg sync.WaitGroup
+	for range r.opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer report_panic()
+			for item := range jobs {
+				r.process_item(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		jobs <- item
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// commit_db_writes applies the database updates of the run, all of them or
+// none.
+func (r *fetchRun) commit_db_writes(db *sql.DB) {
+	defer timings.since(TIMING_DISK, time.Now())
+	updated, err := r.dbWrites.commit(db, r.dirs.DbFilePath)
+	if err != nil {
+		log.Error("Error while updating Lutris database, it was left unchanged", "err", err)
+		return
+	}
+	if updated > 0 {
+		log.Info("Lutris database updated", "games", updated)
+	}
+}
+
+func (r *fetchRun) save() {
+	record_run(r.summary)
+	r.save_state()
+	r.checkpoint.remove()
+}
+
+// save_state saves what the run learnt of the games, at checkpoints and at the
+// end of the run.
+func (r *fetchRun) save_state() {
+	if err := r.q.save(); err != nil {
+		log.Error("Error while saving quarantined games", "err", err)
+	}
+	if err := r.cur.save(); err != nil {
+		log.Error("Error while saving curation decisions", "err", err)
+	}
+	if err := r.stats.save(); err != nil {
+		log.Error("Error while saving match statistics", "err", err)
+	}
+	if err := r.manifest.save(); err != nil {
+		log.Error("Error while saving the manifest", "err", err)
+	}
+}
+
+func (r *fetchRun) process_item(item planItem) {
+	g := item.Game
+	retrying := false
+	defer func() {
+		if r.checkpoint.done() {
+			r.save_checkpoint()
+		}
+	}()
+	if r.opts.atomic() {
+		c := r.begin_commit(item)
+		defer func() { r.finish_commit(c, retrying) }()
+	}
+	// art packs and the Steam library come before any API, only curation overrides winning over them
+	missing := slices.DeleteFunc(slices.Clone(item.Kinds), func(kind assetKind) bool {
+		return r.cur.override_url(g.Slug, kind.Name) == "" && r.apply_local_image(g, kind)
+	})
+	if len(missing) == 0 {
+		return
+	}
+	if images, ok := r.opts.Catalog.images(g.Slug); ok {
+		r.fetch_catalog_art(g, images, missing)
+		return
+	}
+
+	id, ok, retry := r.match_game(g)
+	if retry {
+		retrying = true
+		return
+	}
+	if !ok {
+		for _, kind := range missing {
+			r.summary.count(kind, OUTCOME_FAILED)
+		}
+		return
+	}
+
+	// kinds sharing an endpoint, like covers and banners, share one request
+	images := map[string][]grid{}
+	for _, kind := range missing {
+		if _, fetched := images[kind.Endpoint]; fetched || r.cur.override_url(g.Slug, kind.Name) != "" {
+			continue
+		}
+		// asking for every selected kind, not only the missing ones, keeps
+		// the request identical across games so the image cache can answer
+		fetched, err := fetch_steamgriddb_images(kind.Endpoint, id, endpoint_dimensions(r.opts.assets_for(g), kind.Endpoint))
+		if r.retry_later(g, err) {
+			retrying = true
+			return
+		}
+		if err != nil {
+			log.Error("Error while retrieving SteamGridDB "+kind.Endpoint, "game", g.Slug, "err", err)
+		}
+		images[kind.Endpoint] = fetched
+	}
+	for _, kind := range missing {
+		r.fetch_asset(g, id, kind, images[kind.Endpoint])
+	}
+}
+
+func endpoint_dimensions(kinds []assetKind, endpoint string) []string {
+	var dimensions []string
+	for _, kind := range kinds {
+		if kind.Endpoint == endpoint && kind.Dimensions != "" {
+			dimensions = append(dimensions, kind.Dimensions)
+		}
+	}
+	return dimensions
+}
+
+func (r *fetchRun) fetch_asset(g game, id int, kind assetKind, images []grid) {
+	var matching *grid
+	if overrideUrl := r.cur.override_url(g.Slug, kind.Name); overrideUrl != "" {
+		matching = &grid{Url: overrideUrl, Mime: mime_type_from_url(overrideUrl)}
+	} else if r.opts.Interactive {
+		picked, ok := choose_grid(r.in, g, kind, images, r.opts.Viewer, r.opts.Diverse)
+		if !ok {
+			r.summary.add_skipped(g, E_SKIPPED, string(kind.Name)+" skipped")
+			r.summary.count(kind, OUTCOME_SKIPPED)
+			return
+		}
+		matching = picked
+		if matching != nil {
+			r.cur.override(g.Slug, kind.Name, matching.Url)
+		}
+	} else {
+		matching = select_image(images, kind)
+	}
+	fallback := r.manifest.is_fallback(g.Slug, kind.Name)
+	if matching == nil && fallback {
+		log.Debug("Keeping fallback "+string(kind.Name)+", SteamGridDB still has none", "game", g.Slug)
+		r.summary.count(kind, OUTCOME_SKIPPED)
+		return
+	}
+	if matching == nil && r.opts.ScreenshotBanners && kind.Name == ASSET_BANNER && !r.has_hero(id) {
+		r.fetch_screenshot_banner(g, kind)
+		return
+	}
+	if matching == nil {
+		log.Error("Error while downloading "+string(kind.Name), "game", g.Slug, "code", E_NO_IMAGE, "err", "No image found with expected format")
+		r.summary.add_failed(g, E_NO_IMAGE, "no "+string(kind.Name)+" found with expected format")
+		r.summary.count(kind, OUTCOME_FAILED)
+		r.results.emit(&GameFailed{Slug: g.Slug, Name: g.Name, Asset: kind.Name, Code: E_NO_IMAGE, Reason: "no " + string(kind.Name) + " found with expected format"})
+		return
+	}
+	// overrides are the only images without a SteamGridDB ID
+	provider := PROVIDER_STEAMGRIDDB
+	if matching.Id == 0 {
+		provider = PROVIDER_OVERRIDE
+	}
+	r.download_asset(g, kind, matching, provider, fallback)
+}
+
+// download_asset downloads the image picked for the asset and writes it,
+// provider being where it was picked from.
+func (r *fetchRun) download_asset(g game, kind assetKind, matching *grid, provider string, fallback bool) {
+	if r.opts.DryRun {
+		log.Info("Would download "+string(kind.Name), "game", g.Slug, "url", matching.Url)
+		r.summary.count(kind, OUTCOME_FETCHED)
+		return
+	}
+	log.Info("Downloading "+string(kind.Name)+"...", "game", g.Slug)
+	fail := func(err error) {
+		code := error_code(err)
+		log.Error("Error while downloading "+string(kind.Name), "game", g.Slug, "code", code, "err", err)
+		r.summary.add_failed(g, code, err.Error())
+		r.summary.count(kind, OUTCOME_FAILED)
+		r.results.emit(&GameFailed{Slug: g.Slug, Name: g.Name, Asset: kind.Name, Url: matching.Url, Code: code, Reason: err.Error()})
+	}
+	body, err := download_image(matching)
+	if err != nil {
+		fail(err)
+		return
+	}
+	r.offload(g, r.opts.Processing, func() {
+		err := r.write(g, kind, body, matching.Mime, matching.Url, r.opts.Processing, func(file string) {
+			r.downloaded(g, kind, matching, provider, file, fallback)
+		})
+		if err != nil {
+			fail(err)
+		}
+	})
+}
+
+// downloaded records the asset written from the SteamGridDB image, the
+// override or the tool catalog.
+func (r *fetchRun) downloaded(g game, kind assetKind, matching *grid, provider, file string, fallback bool) {
+	source := assetSource{Provider: provider, Url: matching.Url, ImageId: matching.Id, FetchedAt: time.Now()}
+	record_provenance(r.opts.Provenance, file, source)
+	if fallback || r.opts.Refresh {
+		remove_other_asset_files(r.dirs, kind, g.Slug, file)
+	}
+	if fallback {
+		r.manifest.clear_fallback(g.Slug, kind.Name)
+		log.Info("Fallback "+string(kind.Name)+" replaced", "game", g.Slug)
+	}
+	r.summary.count(kind, OUTCOME_FETCHED)
+	r.summary.add_fetched(g, kind)
+	r.dbWrites.mark_custom(g, kind)
+	r.results.emit(&AssetDownloaded{Slug: g.Slug, Name: g.Name, Asset: kind.Name, Path: file, Url: matching.Url})
+}
+
+// localImage is an image found on disk, which costs no API call.
+type localImage struct {
+	Data     []byte
+	Url      string
+	Provider string
+	From     string
+}
+
+// local_image looks for the asset in the installed art packs first, then in
+// the Steam library.
+func (r *fetchRun) local_image(g game, kind assetKind) (localImage, bool) {
+	if data, packUrl, ok := r.packs.get().image(g, kind); ok {
+		return localImage{data, packUrl, PROVIDER_PACK, "art pack"}, true
+	}
+	if file, ok := r.steam.get().image(g, kind); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Warn("Error while reading Steam library image", "file", file, "err", err)
+			return localImage{}, false
+		}
+		return localImage{data, "file://" + file, PROVIDER_STEAM, "Steam library"}, true
+	}
+	return localImage{}, false
+}
+
+// apply_local_image writes the image found on disk for the asset, telling
+// whether there was one.
+func (r *fetchRun) apply_local_image(g game, kind assetKind) bool {
+	img, ok := r.local_image(g, kind)
+	if !ok {
+		return false
+	}
+	if r.opts.DryRun {
+		log.Info("Would apply "+string(kind.Name)+" from "+img.From, "game", g.Slug, "url", img.Url)
+		r.summary.count(kind, OUTCOME_FETCHED)
+		return true
+	}
+	log.Info("Applying "+string(kind.Name)+" from "+img.From+"...", "game", g.Slug, "url", img.Url)
+	// processed inline, a failure giving the APIs their chance instead
+	err := r.write(g, kind, img.Data, mime_type_from_url(img.Url), img.Url, r.opts.Processing, func(file string) {
+		record_provenance(r.opts.Provenance, file, assetSource{Provider: img.Provider, Url: img.Url, FetchedAt: time.Now()})
+		if r.opts.Refresh || r.manifest.is_fallback(g.Slug, kind.Name) {
+			remove_other_asset_files(r.dirs, kind, g.Slug, file)
+			r.manifest.clear_fallback(g.Slug, kind.Name)
+		}
+		r.summary.count(kind, OUTCOME_FETCHED)
+		r.summary.add_fetched(g, kind)
+		r.dbWrites.mark_custom(g, kind)
+		r.results.emit(&AssetDownloaded{Slug: g.Slug, Name: g.Name, Asset: kind.Name, Path: file, Url: img.Url})
+	})
+	if err != nil {
+		log.Error("Error while applying "+string(kind.Name)+" from "+img.From, "game", g.Slug, "err", err)
+		return false
+	}
+	return true
+}
+
+// has_hero tells whether SteamGridDB has a hero for the game, errors counting
+// as having one so a fallback is only used when it surely has none.
+func (r *fetchRun) has_hero(id int) bool {
+	heroes, err := sgdbImages.get("heroes", id, nil)
+	return err != nil || len(heroes) > 0
+}
+
+// fetch_screenshot_banner crops the first IGDB screenshot of the game into a
+// banner, recorded as a fallback in the manifest.
+func (r *fetchRun) fetch_screenshot_banner(g game, kind assetKind) {
+	fail := func(code, reason string) {
+		log.Error("Error while downloading fallback "+string(kind.Name), "game", g.Slug, "code", code, "err", reason)
+		r.summary.add_failed(g, code, reason)
+		r.summary.count(kind, OUTCOME_FAILED)
+		r.results.emit(&GameFailed{Slug: g.Slug, Name: g.Name, Asset: kind.Name, Code: code, Reason: reason})
+	}
+	screenshot, err := igdb.first_screenshot(context.Background(), normalize_name(g.Name))
+	if err != nil {
+		fail(error_code(err), err.Error())
+		return
+	}
+	if screenshot == "" {
+		fail(E_NO_IMAGE, "no "+string(kind.Name)+" found with expected format, nor IGDB screenshot")
+		return
+	}
+	if r.opts.DryRun {
+		log.Info("Would download fallback "+string(kind.Name)+" from a screenshot", "game", g.Slug, "url", screenshot)
+		r.summary.count(kind, OUTCOME_FETCHED)
+		return
+	}
+	log.Info("Downloading fallback "+string(kind.Name)+" from a screenshot...", "game", g.Slug)
+	// the screenshot is always cropped, whatever --resize says
+	proc := r.opts.Processing
+	proc.Resize = true
+	body, err := download_image(&grid{Url: screenshot, Mime: MIME_TYPE_JPEG})
+	if err != nil {
+		fail(error_code(err), err.Error())
+		return
+	}
+	r.offload(g, proc, func() {
+		err := r.write(g, kind, body, MIME_TYPE_JPEG, screenshot, proc, func(file string) {
+			record_provenance(r.opts.Provenance, file, assetSource{Provider: PROVIDER_IGDB, Url: screenshot, FetchedAt: time.Now()})
+			r.manifest.record_fallback(g.Slug, kind.Name, screenshot)
+			r.summary.count(kind, OUTCOME_FETCHED)
+			r.summary.add_fetched(g, kind)
+			r.dbWrites.mark_custom(g, kind)
+			r.results.emit(&AssetDownloaded{Slug: g.Slug, Name: g.Name, Asset: kind.Name, Path: file, Url: screenshot, Fallback: true})
+		})
+		if err != nil {
+			fail(error_code(err), err.Error())
+		}
+	})
+}
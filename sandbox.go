@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/charmbracelet/log"
+)
+
+// SANDBOXED_ENV is set for the process started inside the sandbox, so it
+// does not try to enter it again.
+const SANDBOXED_ENV = "LUTRIS_COVER_ART_SANDBOXED"
+
+// SANDBOX_SYSTEM_DIRS hold the libraries, certificates, resolver
+// configuration and image viewers the tool needs to read or run.
+var SANDBOX_SYSTEM_DIRS = []string{"/usr", "/lib", "/lib64", "/lib32", "/bin", "/sbin", "/etc", "/opt", "/nix/store", "/var/lib/flatpak"}
+
+// SANDBOX_OUTPUT_FLAGS are the flags of the files commands write, the only
+// files writable outside the directories of Lutris and of the tool.
+var SANDBOX_OUTPUT_FLAGS = []string{"o", "output"}
+
+// SANDBOX_INPUT_FLAGS are the flags of the files commands read, the only
+// files readable in the current directory along with .env.
+var SANDBOX_INPUT_FLAGS = []string{"from-backup", "sign", "metadata", "add-file"}
+
+// SANDBOX_INPUT_COMMANDS are the commands whose arguments are files to read.
+var SANDBOX_INPUT_COMMANDS = []string{"import-curation", "install-pack"}
+
+// sandboxRule gives access to a path and everything below it, reading only
+// unless Write is set.
+type sandboxRule struct {
+	Path  string
+	Write bool
+}
+
+// harden restricts the process to the Lutris directories, the directories of
+// the tool and the network for --hardened, running on unrestricted when the
+// system has no way to.
+func harden(fs *flag.FlagSet) {
+	if os.Getenv(SANDBOXED_ENV) != "" {
+		return
+	}
+	rules, err := sandbox_rules(fs)
+	if err != nil {
+		log.Fatal("An error occurred while preparing the sandbox", "err", err)
+	}
+	// only returns when the process could not be restricted at all
+	if err := enter_sandbox(rules); err != nil {
+		log.Warn("Running without a sandbox", "err", err)
+	}
+}
+
+// sandbox_rules creates the directories written to that do not exist yet,
+// access being granted to existing paths only.
+func sandbox_rules(fs *flag.FlagSet) ([]sandboxRule, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	dirs := lutris_dirs_in(homeDir)
+	lutrisCacheDir, err := lutris_cache_dir()
+	if err != nil {
+		return nil, err
+	}
+	stateDir, err := get_state_dir()
+	if err != nil {
+		return nil, err
+	}
+	cacheDir, err := get_cache_dir()
+	if err != nil {
+		return nil, err
+	}
+	configDir, err := get_config_dir()
+	if err != nil {
+		return nil, err
+	}
+	// the current directory often is the home directory, only its .env
+	// and the files given to commands are readable
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	writable := []string{filepath.Dir(dirs.DbFilePath), lutrisCacheDir, dirs.IconsDirPath, stateDir, cacheDir, os.TempDir()}
+	readable := append([]string{configDir, filepath.Join(workDir, ".env"), filepath.Join(homeDir, ".local/share/flatpak/app/net.lutris.Lutris")}, SANDBOX_SYSTEM_DIRS...)
+	for _, dir := range STEAM_DIRS {
+		readable = append(readable, filepath.Join(homeDir, dir))
+	}
+	for _, name := range []string{"output-dir", "dest"} {
+		if f := fs.Lookup(name); f != nil && f.Value.String() != "" {
+			writable = append(writable, f.Value.String())
+		}
+	}
+	// the helper creates its socket next to where it will be
+	if f := fs.Lookup("socket"); f != nil && f.Value.String() != "" {
+		writable = append(writable, filepath.Dir(f.Value.String()))
+	}
+	var outputs []string
+	for _, name := range SANDBOX_OUTPUT_FLAGS {
+		if f := fs.Lookup(name); f != nil && f.Value.String() != "" {
+			outputs = append(outputs, f.Value.String())
+		}
+	}
+	for _, name := range SANDBOX_INPUT_FLAGS {
+		if f := fs.Lookup(name); f != nil && f.Value.String() != "" {
+			readable = append(readable, f.Value.String())
+		}
+	}
+	if slices.Contains(SANDBOX_INPUT_COMMANDS, fs.Name()) {
+		readable = append(readable, fs.Args()...)
+	}
+	if exe, err := os.Executable(); err == nil {
+		readable = append(readable, exe)
+	}
+
+	rules := []sandboxRule{{Path: os.DevNull, Write: true}}
+	for _, dir := range writable {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		rules = append(rules, sandboxRule{Path: dir, Write: true})
+	}
+	for _, file := range outputs {
+		// Landlock only grants access to existing files, so the file is
+		// created empty, only readable by the user as it may be a key
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		rules = append(rules, sandboxRule{Path: file, Write: true})
+	}
+	for _, path := range readable {
+		if _, err := os.Stat(path); err == nil {
+			rules = append(rules, sandboxRule{Path: path})
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,199 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/charmbracelet/log"
+)
+
+// the syscall package predates Landlock, whose syscall numbers are the same
+// on every architecture
+const (
+	SYS_LANDLOCK_CREATE_RULESET = 444
+	SYS_LANDLOCK_ADD_RULE       = 445
+	SYS_LANDLOCK_RESTRICT_SELF  = 446
+
+	LANDLOCK_CREATE_RULESET_VERSION = 1
+	LANDLOCK_RULE_PATH_BENEATH      = 1
+)
+
+const (
+	LANDLOCK_ACCESS_FS_EXECUTE = 1 << iota
+	LANDLOCK_ACCESS_FS_WRITE_FILE
+	LANDLOCK_ACCESS_FS_READ_FILE
+	LANDLOCK_ACCESS_FS_READ_DIR
+	LANDLOCK_ACCESS_FS_REMOVE_DIR
+	LANDLOCK_ACCESS_FS_REMOVE_FILE
+	LANDLOCK_ACCESS_FS_MAKE_CHAR
+	LANDLOCK_ACCESS_FS_MAKE_DIR
+	LANDLOCK_ACCESS_FS_MAKE_REG
+	LANDLOCK_ACCESS_FS_MAKE_SOCK
+	LANDLOCK_ACCESS_FS_MAKE_FIFO
+	LANDLOCK_ACCESS_FS_MAKE_BLOCK
+	LANDLOCK_ACCESS_FS_MAKE_SYM
+	// LANDLOCK_ACCESS_FS_REFER needs Landlock ABI 2, TRUNCATE ABI 3.
+	LANDLOCK_ACCESS_FS_REFER
+	LANDLOCK_ACCESS_FS_TRUNCATE
+)
+
+const LANDLOCK_ACCESS_FS_READ = LANDLOCK_ACCESS_FS_EXECUTE | LANDLOCK_ACCESS_FS_READ_FILE | LANDLOCK_ACCESS_FS_READ_DIR
+
+// LANDLOCK_ACCESS_FILE are the only rights that apply to a file rather than
+// a directory.
+const LANDLOCK_ACCESS_FILE = LANDLOCK_ACCESS_FS_EXECUTE | LANDLOCK_ACCESS_FS_WRITE_FILE | LANDLOCK_ACCESS_FS_READ_FILE | LANDLOCK_ACCESS_FS_TRUNCATE
+
+const O_PATH = 0x200000
+
+const (
+	PR_SET_NO_NEW_PRIVS = 38
+	PR_SET_SECCOMP      = 22
+	SECCOMP_MODE_FILTER = 2
+	SECCOMP_RET_ALLOW   = 0x7fff0000
+	SECCOMP_RET_ERRNO   = 0x00050000
+	// X32_SYSCALL_BIT marks the syscalls of the x32 ABI, which amd64
+	// kernels take under the same audit architecture.
+	X32_SYSCALL_BIT = 0x40000000
+)
+
+// AUDIT_ARCHS identify the architectures seccomp filters are written for.
+var AUDIT_ARCHS = map[string]uint32{
+	"amd64":   0xc000003e,
+	"arm64":   0xc00000b7,
+	"386":     0x40000003,
+	"arm":     0x40000028,
+	"riscv64": 0xc00000f3,
+}
+
+// SECCOMP_DENIED are the syscalls nothing in the tool makes, which would let
+// it read other processes or change the system.
+var SECCOMP_DENIED = []uint32{
+	syscall.SYS_PTRACE, syscall.SYS_MOUNT, syscall.SYS_UMOUNT2, syscall.SYS_PIVOT_ROOT, syscall.SYS_CHROOT,
+	syscall.SYS_KEXEC_LOAD, syscall.SYS_INIT_MODULE, syscall.SYS_DELETE_MODULE,
+	syscall.SYS_REBOOT, syscall.SYS_SWAPON, syscall.SYS_SWAPOFF, syscall.SYS_ACCT,
+}
+
+type landlockRulesetAttr struct {
+	handledAccessFs uint64
+}
+
+// landlockPathBeneathAttr is packed in C, its padding being ignored.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// enter_sandbox restricts the current thread with Landlock and seccomp and
+// starts the tool again from it, as both are inherited by the new process
+// but only apply to the thread setting them up, and the Go runtime, cgo
+// included, has several. It only returns when neither could be set up.
+func enter_sandbox(rules []sandboxRule) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
+		return fmt.Errorf("setting no_new_privs: %w", errno)
+	}
+	landlockErr := restrict_paths(rules)
+	if landlockErr != nil {
+		log.Warn("The file system is not restricted", "err", landlockErr)
+	}
+	seccompErr := restrict_syscalls()
+	if seccompErr != nil {
+		log.Warn("System calls are not restricted", "err", seccompErr)
+	}
+	if landlockErr != nil && seccompErr != nil {
+		return errors.New("neither Landlock nor seccomp is available")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(exe, os.Args, append(os.Environ(), SANDBOXED_ENV+"=1"))
+}
+
+// restrict_paths only grants the rights the running kernel knows of, older
+// ABIs leaving the others unrestricted.
+func restrict_paths(rules []sandboxRule) error {
+	abi, _, errno := syscall.Syscall(SYS_LANDLOCK_CREATE_RULESET, 0, 0, LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("Landlock is unavailable: %w", errno)
+	}
+	handled := uint64(LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+	if abi >= 2 {
+		handled |= LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	attr := landlockRulesetAttr{handledAccessFs: handled}
+	fd, _, errno := syscall.Syscall(SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("creating the Landlock ruleset: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	for _, rule := range rules {
+		access := uint64(LANDLOCK_ACCESS_FS_READ)
+		if rule.Write {
+			access = handled
+		}
+		info, err := os.Stat(rule.Path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			access &= LANDLOCK_ACCESS_FILE
+		}
+		pathFd, err := syscall.Open(rule.Path, O_PATH|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", rule.Path, err)
+		}
+		beneath := landlockPathBeneathAttr{allowedAccess: access & handled, parentFd: int32(pathFd)}
+		_, _, errno := syscall.Syscall6(SYS_LANDLOCK_ADD_RULE, fd, LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&beneath)), 0, 0, 0)
+		syscall.Close(pathFd)
+		if errno != 0 {
+			return fmt.Errorf("allowing %s: %w", rule.Path, errno)
+		}
+	}
+	if _, _, errno := syscall.Syscall(SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("enforcing the Landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// restrict_syscalls makes the denied syscalls, and those of other
+// architectures and of x32, fail with EPERM.
+func restrict_syscalls() error {
+	arch, ok := AUDIT_ARCHS[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("no seccomp filter for %s", runtime.GOARCH)
+	}
+	deny := syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: SECCOMP_RET_ERRNO | uint32(syscall.EPERM)}
+	filter := []syscall.SockFilter{
+		// seccomp_data starts with the syscall number, then the architecture
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 4},
+		{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: 1, K: arch},
+		deny,
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 0},
+	}
+	if runtime.GOARCH == "amd64" {
+		// x32 numbers would not match the denied ones
+		filter = append(filter, syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K, Jt: uint8(len(SECCOMP_DENIED) + 1), K: X32_SYSCALL_BIT})
+	}
+	for i, nr := range SECCOMP_DENIED {
+		// jumps over the remaining checks and the allow to the deny
+		filter = append(filter, syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: uint8(len(SECCOMP_DENIED) - i), K: nr})
+	}
+	filter = append(filter, syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: SECCOMP_RET_ALLOW}, deny)
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_SECCOMP, SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("installing the seccomp filter: %w", errno)
+	}
+	return nil
+}
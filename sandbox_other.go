@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// the sandbox relies on Landlock and seccomp, which only Linux has
+func enter_sandbox(rules []sandboxRule) error {
+	return errors.New("--hardened is only supported on Linux")
+}
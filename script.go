@@ -1,26 +1,31 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/muesli/termenv"
 )
 
 var SGDB_API_KEY string
 
-const SGDB_API_URL = "https://www.steamgriddb.com/api/v2/"
+const SGDB_DEFAULT_API_URL = "https://www.steamgriddb.com/api/v2/"
+
+// SGDB_API_URL can point at any SteamGridDB-compatible API, such as a caching
+// proxy or a mirror shared over a LAN, with SGDB_API_URL or --api-url.
+var SGDB_API_URL = SGDB_DEFAULT_API_URL
+
 const SGDB_COVER_FORMAT = "600x900"
 const SGDB_COVER_WIDTH = 600
 const SGDB_BANNER_FORMAT = "920x430"
@@ -28,63 +33,140 @@ const SGDB_BANNER_WIDTH = 920
 const MIME_TYPE_JPEG = "image/jpeg"
 const MIME_TYPE_PNG = "image/png"
 
+var COMMANDS = map[string]func(args []string){
+	"fetch":        run_fetch,
+	"refresh":      run_refresh,
+	"clean":        run_clean,
+	"synthesize":   run_synthesize,
+	"review":       run_review,
+	"watch":        run_watch,
+	"helper":       run_helper,
+	"stats":        run_stats,
+	"estimate":     run_estimate,
+	"plan":         run_plan,
+	"serve":        run_serve,
+	"cache-server": run_cache_server,
+	"log":          run_log,
+	"digest":       run_digest,
+	"info":         run_info,
+	"export":       run_export,
+	"note":         run_note,
+	"config":       run_config,
+
+	"install-pack": run_install_pack,
+	"list-packs":   run_list_packs,
+	"remove-pack":  run_remove_pack,
+
+	"export-curation": run_export_curation,
+	"import-curation": run_import_curation,
+	"curation-keygen": run_curation_keygen,
+}
+
 func main() {
+	defer report_panic()
 	log.SetReportTimestamp(false)
+	// the color profile is otherwise detected on crashLog, which is no
+	// terminal
+	log.SetOutput(crashLog)
+	log.SetColorProfile(termenv.NewOutput(os.Stderr).EnvColorProfile())
 	godotenv.Load()
-	SGDB_API_KEY = os.Getenv("SGDB_API_KEY")
-	if SGDB_API_KEY == "" {
-		log.Fatal("Please set the SGDB_API_KEY environment variable with your StreamGridDB API key")
-	}
 
-	lutrisDirs, err := get_lutris_dir()
-	if err != nil {
-		log.Fatal("An error occurred while retrieving Lutris directories", "err", err)
+	// fetching is what runs without a command
+	command, args := "fetch", os.Args[1:]
+	if len(os.Args) > 1 && COMMANDS[os.Args[1]] != nil {
+		command, args = os.Args[1], os.Args[2:]
 	}
+	auditCommand = command
+	COMMANDS[command](args)
+}
 
-	db, err := connect_to_lutris_db(lutrisDirs.DbFilePath)
-	if err != nil {
-		log.Fatal("An error occurred while connecting to Lutris database", "err", err)
+func run_fetch(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	flags := add_fetch_flags(fs)
+	fs.BoolVar(&flags.opts.Interactive, "interactive", false, "pick the matching game and grids yourself before anything is written")
+	fs.BoolVar(&flags.opts.DryRun, "dry-run", false, "only report what would be matched and downloaded")
+	fs.StringVar(&flags.opts.Viewer, "viewer", "", "image viewer command used to preview candidates in interactive mode, e.g. feh")
+	fs.BoolVar(&flags.opts.Diverse, "diverse", false, "in interactive mode, show images from different uploaders and styles first")
+	fs.IntVar(&flags.opts.CheckpointEvery, "checkpoint-every", DEFAULT_CHECKPOINT_EVERY, "save the progress of the run every so many games, 0 to never")
+	fs.BoolVar(&flags.opts.Resume, "resume", false, "continue the last run that was interrupted, leaving out the games it found no match for")
+	src := add_source_flags(fs)
+	parse_flags(fs, args)
+	opts := flags.options()
+	if opts.MarkCustom && (src.Backup != "" || src.OutputDir != "") {
+		log.Warn("Ignoring --mark-custom, the Lutris database is not the one getting the art")
+		opts.MarkCustom = false
 	}
+
+	lutrisDirs, db := open_lutris(*src)
 	defer db.Close()
 
-	slugs, err := select_game_slugs(db)
+	games, err := load_library(db, *src)
 	if err != nil {
 		log.Fatal("An error occurred while fetching installed games", "err", err)
 	}
-	totalSlugs := len(slugs)
-	slugs = filter_game_slugs_with_missing_assets(lutrisDirs, slugs)
-	if len(slugs) == 0 {
-		log.Info(fmt.Sprintf("%d games found, none are missing assets!", totalSlugs))
+	games = opts.Where.filter(games)
+	run := new_fetch_run(opts, lutrisDirs)
+	plan := run.checkpoint.skip(plan_assets(lutrisDirs, opts, run.manifest, games))
+	if len(plan.Items) == 0 {
+		log.Info(fmt.Sprintf("%d games found, none are missing assets!", len(games)))
+		run.checkpoint.remove()
+		run.profiler.stop()
 		os.Exit(0)
 	}
-	log.Info(fmt.Sprintf("%d games found, %d games are missing one or more assets", totalSlugs, len(slugs)))
+	log.Info(fmt.Sprintf("%d games found, %d games are missing %d assets", len(games), len(plan.Items), plan.pairs()))
 
-	for _, slug := range slugs {
-		id, err := fetch_steamgriddb_game_id(slug)
-		if err != nil {
-			log.Error("Error while retrieving SteamGridDB game ID", "game", slug, "err", err)
-			continue
-		}
-		grids, err := fetch_steamgriddb_grids(id)
+	run.process_plan(plan)
+	run.close_results()
+	run.commit_db_writes(db)
+	run.summary.print(opts.all_assets(), opts.DryRun)
+	run.profiler.stop()
+	if !opts.DryRun {
+		run.save()
+	}
+}
+
+// add_api_flag is added to every command talking to the SteamGridDB API.
+func add_api_flag(fs *flag.FlagSet) {
+	fs.Func("api-url", "base URL of a SteamGridDB-compatible API, e.g. a caching proxy or a LAN mirror (default $SGDB_API_URL or "+SGDB_DEFAULT_API_URL+")", set_sgdb_api_url)
+}
+
+func set_sgdb_api_url(rawUrl string) error {
+	if !is_http_url(rawUrl) {
+		return fmt.Errorf("%q is not an http or https URL", rawUrl)
+	}
+	SGDB_API_URL = rawUrl
+	return nil
+}
+
+// open_lutris checks the API key and opens the Lutris database, exiting when
+// either is unusable.
+func open_lutris(src librarySource) (lutrisDirs, *sql.DB) {
+	SGDB_API_KEY = os.Getenv("SGDB_API_KEY")
+	if SGDB_API_KEY == "" {
+		log.Fatal("Please set the SGDB_API_KEY environment variable with your StreamGridDB API key")
+	}
+
+	lutrisDirs, err := get_lutris_dir()
+	if err != nil {
+		log.Fatal("An error occurred while retrieving Lutris directories", "err", err)
+	}
+	if src.OutputDir != "" {
+		dbFilePath := lutrisDirs.DbFilePath
+		lutrisDirs = lutris_dirs_in(src.OutputDir)
+		lutrisDirs.DbFilePath = dbFilePath
+	}
+	if src.Backup != "" {
+		lutrisDirs.DbFilePath, err = backup_db(src.Backup)
 		if err != nil {
-			log.Error("Error while retrieving SteamGridDB grids", "game", slug, "err", err)
-			continue
-		}
-		if assets_missing(lutrisDirs.CoverArtDirPath, slug) {
-			log.Info("Downloading cover...", "game", slug)
-			err = download_asset(lutrisDirs.CoverArtDirPath, slug, SGDB_COVER_WIDTH, grids)
-			if err != nil {
-				log.Error("Error while downloading cover", "game", slug, "err", err)
-			}
-		}
-		if assets_missing(lutrisDirs.BannersDirPath, slug) {
-			log.Info("Downloading banner...", "game", slug)
-			err = download_asset(lutrisDirs.BannersDirPath, slug, SGDB_BANNER_WIDTH, grids)
-			if err != nil {
-				log.Error("Error while downloading banner", "game", slug, "err", err)
-			}
+			log.Fatal("An error occurred while reading the Lutris backup", "err", err)
 		}
 	}
+
+	db, err := connect_to_lutris_db(lutrisDirs.DbFilePath)
+	if err != nil {
+		log.Fatal("An error occurred while connecting to Lutris database", "err", err)
+	}
+	return apply_lutris_compat(db, lutrisDirs, src.OutputDir == ""), db
 }
 
 func get_lutris_dir() (lutrisDirs, error) {
@@ -92,179 +174,247 @@ func get_lutris_dir() (lutrisDirs, error) {
 	if err != nil {
 		return lutrisDirs{}, err
 	}
+	return lutris_dirs_in(homeDir), nil
+}
+
+func lutris_dirs_in(homeDir string) lutrisDirs {
 	lutrisDir := filepath.Join(homeDir, ".local", "share", "lutris")
 	return lutrisDirs{
 		DbFilePath:      filepath.Join(lutrisDir, "pga.db"),
 		BannersDirPath:  filepath.Join(lutrisDir, "banners"),
 		CoverArtDirPath: filepath.Join(lutrisDir, "coverart"),
-	}, nil
+		IconsDirPath:    filepath.Join(homeDir, ".local", "share", "icons", "hicolor", "128x128", "apps"),
+		HeroesDirPath:   filepath.Join(lutrisDir, "heroes"),
+		LogosDirPath:    filepath.Join(lutrisDir, "logos"),
+	}
 }
 
 type lutrisDirs struct {
 	DbFilePath      string
 	BannersDirPath  string
 	CoverArtDirPath string
+	IconsDirPath    string
+	HeroesDirPath   string
+	LogosDirPath    string
+	// Compat is how the installed Lutris version behaves.
+	Compat lutrisCompat
 }
 
 func connect_to_lutris_db(path string) (*sql.DB, error) {
 	return sql.Open("sqlite3", path)
 }
 
-func select_game_slugs(db *sql.DB) ([]string, error) {
-	var slugs []string
-	rows, err := db.Query("SELECT slug FROM games")
+// GAMES_QUERY normalizes `updated`, stored either as a unix timestamp or a
+// date string depending on the Lutris version, to a unix timestamp.
+const GAMES_QUERY = `SELECT rowid AS row_id, slug, COALESCE(name, ''),
+	COALESCE(service, ''), COALESCE(service_id, ''), COALESCE(runner, ''),
+	CASE typeof(updated)
+		WHEN 'integer' THEN updated
+		WHEN 'real' THEN CAST(updated AS INTEGER)
+		WHEN 'text' THEN COALESCE(CAST(strftime('%s', updated) AS INTEGER), 0)
+		ELSE 0
+	END AS updated_at,
+	COALESCE(CAST(lastplayed AS INTEGER), 0), COALESCE(CAST(playtime AS REAL), 0)
+	FROM games`
+
+func select_games(db *sql.DB) ([]game, error) {
+	return query_games(db, GAMES_QUERY)
+}
+
+func query_games(db *sql.DB, query string, args ...any) ([]game, error) {
+	var games []game
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return slugs, err
+		return games, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		var slug string
-		rows.Scan(&slug)
-		if slug != "" {
-			slugs = append(slugs, slug)
+		var g game
+		rows.Scan(&g.Id, &g.Slug, &g.Name, &g.Service, &g.ServiceId, &g.Runner, &g.Updated, &g.LastPlayed, &g.Playtime)
+		if g.Slug == "" {
+			continue
+		}
+		if !safe_slug(g.Slug) {
+			log.Warn("Ignoring game whose slug cannot be used as a file name", "game", g.Slug)
+			continue
 		}
+		games = append(games, g)
+	}
+	if err := rows.Err(); err != nil {
+		return games, err
 	}
-	return slugs, nil
+	return games, select_categories(db, games)
 }
 
-func filter_game_slugs_with_missing_assets(dirs lutrisDirs, slugs []string) []string {
-	var filtered []string
-	for _, slug := range slugs {
-		if assets_missing(dirs.CoverArtDirPath, slug) || assets_missing(dirs.BannersDirPath, slug) {
-			filtered = append(filtered, slug)
+// CATEGORIES_QUERY leaves out the categories Lutris uses internally, such as
+// .hidden, but the one hiding games.
+const CATEGORIES_QUERY = `SELECT games_categories.game_id, categories.name
+	FROM games_categories JOIN categories ON categories.id = games_categories.category_id
+	WHERE categories.name NOT LIKE '.%' OR categories.name = '` + HIDDEN_CATEGORY + `'
+	ORDER BY categories.name`
+
+const HIDDEN_CATEGORY = ".hidden"
+
+// select_categories fills in the categories of the games, the Lutris versions
+// without categories leaving them empty.
+func select_categories(db *sql.DB, games []game) error {
+	rows, err := db.Query(CATEGORIES_QUERY)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil
 		}
+		return err
 	}
-	return filtered
-}
-
-func assets_missing(assetDir, slug string) bool {
-	if _, err := os.Stat(filepath.Join(assetDir, fmt.Sprint(slug, ".jpg"))); err == nil {
-		return false
+	defer rows.Close()
+	byId := map[int64]*game{}
+	for i := range games {
+		byId[games[i].Id] = &games[i]
 	}
-	if _, err := os.Stat(filepath.Join(assetDir, fmt.Sprint(slug, ".png"))); err == nil {
-		return false
+	for rows.Next() {
+		var id int64
+		var name string
+		rows.Scan(&id, &name)
+		switch g := byId[id]; {
+		case g == nil:
+		case name == HIDDEN_CATEGORY:
+			g.Hidden = true
+		default:
+			g.Categories = append(g.Categories, name)
+		}
 	}
-	return true
+	return rows.Err()
+}
+
+// safe_slug rejects slugs that would write outside the asset directories.
+func safe_slug(slug gameSlug) bool {
+	return slug != "." && slug != ".." && !strings.ContainsAny(string(slug), "/\\\x00")
 }
 
-func fetch_steamgriddb_game_id(slug string) (int, error) {
+// sgdb_url escapes every path segment on its own, so titles containing
+// slashes, question marks or non-ASCII characters stay a single segment.
+func sgdb_url(segments ...string) (*url.URL, error) {
 	u, err := url.Parse(SGDB_API_URL)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	u.Path = path.Join(u.Path, "search/autocomplete", slug)
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
-	req.Header.Add("Authorization", "Bearer "+SGDB_API_KEY)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return 0, err
+	rawPath := strings.TrimSuffix(u.EscapedPath(), "/")
+	for _, segment := range segments {
+		escaped := url.PathEscape(segment)
+		if segment == "." || segment == ".." {
+			escaped = strings.ReplaceAll(segment, ".", "%2E")
+		}
+		rawPath += "/" + escaped
 	}
-	defer resp.Body.Close()
+	u.RawPath = rawPath
+	u.Path, err = url.PathUnescape(rawPath)
+	return u, err
+}
 
-	body, err := io.ReadAll(resp.Body)
+func search_steamgriddb_games(ctx context.Context, term string) ([]gameData, error) {
+	u, err := sgdb_url("search", "autocomplete", term)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	var searchResp searchResponse
-	err = json.Unmarshal(body, &searchResp)
-	if err != nil {
-		return 0, err
-	}
-	if len(searchResp.Games) == 0 {
-		return 0, errors.New("no game found")
-	}
-	return searchResp.Games[0].Id, nil
-}
-
-type searchResponse struct {
-	Games []gameData `json:"data"`
+	return sgdb_get_list[gameData](ctx, u.String())
 }
 
 type gameData struct {
-	Id   int    `json:"id"`
-	Name string `json:"name"`
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Verified bool   `json:"verified"`
 }
 
 func fetch_steamgriddb_grids(gameId int) ([]grid, error) {
-	u, err := url.Parse(SGDB_API_URL)
+	return fetch_steamgriddb_images("grids", gameId, []string{SGDB_COVER_FORMAT, SGDB_BANNER_FORMAT})
+}
+
+// fetch_steamgriddb_images goes through the image cache, an empty list being
+// an error for the callers.
+func fetch_steamgriddb_images(endpoint string, gameId int, dimensions []string) ([]grid, error) {
+	grids, err := sgdbImages.get(endpoint, gameId, dimensions)
 	if err != nil {
 		return []grid{}, err
 	}
-	u.Path = path.Join(u.Path, "grids/game", fmt.Sprint(gameId))
-	params := url.Values{}
-	params.Set("dimensions", strings.Join([]string{SGDB_COVER_FORMAT, SGDB_BANNER_FORMAT}, ","))
-	params.Set("nsfw", "any")
-	params.Set("types", "static")
-	u.RawQuery = params.Encode()
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
-	req.Header.Add("Authorization", "Bearer "+SGDB_API_KEY)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return []grid{}, err
+	if len(grids) == 0 {
+		return []grid{}, with_code(E_NO_IMAGE, fmt.Errorf("No %s yet available", endpoint))
 	}
-	defer resp.Body.Close()
+	return grids, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return []grid{}, err
-	}
-	var gridsResp gridsResponse
-	err = json.Unmarshal(body, &gridsResp)
+func query_steamgriddb_images(endpoint string, gameId int, dimensions []string) ([]grid, error) {
+	u, err := sgdb_url(endpoint, "game", fmt.Sprint(gameId))
 	if err != nil {
-		return []grid{}, err
+		return nil, err
 	}
-	if len(gridsResp.Grids) == 0 {
-		return []grid{}, errors.New("No grid yet available")
+	params := url.Values{}
+	if len(dimensions) > 0 {
+		params.Set("dimensions", strings.Join(dimensions, ","))
 	}
-	return gridsResp.Grids, nil
+	params.Set("nsfw", "any")
+	params.Set("types", sgdb_image_types(endpoint))
+	u.RawQuery = params.Encode()
+	return sgdb_get_list[grid](context.Background(), u.String())
 }
 
-type gridsResponse struct {
-	Grids []grid `json:"data"`
+type grid struct {
+	Id     int        `json:"id"`
+	Url    string     `json:"url"`
+	Thumb  string     `json:"thumb"`
+	Mime   string     `json:"mime"`
+	Width  int        `json:"width"`
+	Height int        `json:"height"`
+	Style  string     `json:"style"`
+	Author gridAuthor `json:"author"`
 }
 
-type grid struct {
-	Url    string `json:"url"`
-	Mime   string `json:"mime"`
-	Width  int    `json:"width"`
-	Height int    `json:"height"`
+type gridAuthor struct {
+	Name    string `json:"name"`
+	Steam64 string `json:"steam64"`
 }
 
-func download_asset(assetDir, slug string, expectedWidth int, grids []grid) error {
-	var matching *grid
-	for _, grid := range grids {
-		if grid.Width == expectedWidth {
-			matching = &grid
-			break
-		}
-	}
-	if matching == nil {
-		return errors.New("No grid found with expected format")
+func download_image(matching *grid) ([]byte, error) {
+	if mime_type_extension(matching.Mime) == "" {
+		return nil, with_code(E_BAD_FORMAT, errors.New("Unexpected image mime type"))
 	}
+	return http_get(context.Background(), matching.Url, false)
+}
 
-	var ext string
-	switch matching.Mime {
-	case MIME_TYPE_JPEG:
-		ext = ".jpg"
-	case MIME_TYPE_PNG:
-		ext = ".png"
-	default:
-		return errors.New("Unexpected image mime type")
-	}
-	out, err := os.Create(filepath.Join(assetDir, fmt.Sprint(slug, ext)))
+// stage_asset processes the image and writes it to a temporary file next to
+// where Lutris reads the asset, source only being what the audit log says it
+// came from once committed.
+func stage_asset(dirs lutrisDirs, kind assetKind, slug gameSlug, body []byte, mime, source string, proc imageProcessing) (stagedAsset, error) {
+	mime, err := kind.check_image(body, mime)
 	if err != nil {
-		return err
+		return stagedAsset{}, err
 	}
-	defer out.Close()
-
-	resp, err := http.Get(matching.Url)
+	data, mime, err := process_image_safely(body, mime, kind, proc, slug)
 	if err != nil {
-		return err
+		return stagedAsset{}, err
 	}
-	defer resp.Body.Close()
-
-	_, err = io.Copy(out, resp.Body)
+	defer timings.since(TIMING_DISK, time.Now())
+	assetDir := asset_dir(dirs, kind)
+	if err := os.MkdirAll(assetDir, 0o755); err != nil {
+		return stagedAsset{}, err
+	}
+	file := filepath.Join(assetDir, asset_file_name(kind, slug, mime_type_extension(mime)))
+	tmp, err := os.CreateTemp(assetDir, filepath.Base(file)+".*.tmp")
 	if err != nil {
-		return err
+		return stagedAsset{}, err
 	}
-	return nil
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return stagedAsset{}, err
+	}
+	// temporary files are only readable by their owner
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return stagedAsset{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return stagedAsset{}, err
+	}
+	return stagedAsset{Kind: kind, File: file, Temp: tmp.Name(), Source: source}, nil
 }
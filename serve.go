@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+const SERVE_DEFAULT_ADDRESS = "localhost:8080"
+const THUMBS_DIR = "thumbs"
+
+// run_serve reviews quarantined games from a browser. Every SteamGridDB
+// request, thumbnails included, is made by the server so the browser never
+// needs the API key, e.g. when picking covers from a phone on the LAN.
+func run_serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	address := fs.String("listen", SERVE_DEFAULT_ADDRESS, "address to listen on, use 0.0.0.0:8080 to reach it from other devices")
+	diverse := fs.Bool("diverse", false, "show covers from different uploaders and styles first")
+	add_api_flag(fs)
+	parse_flags(fs, args)
+
+	SGDB_API_KEY = os.Getenv("SGDB_API_KEY")
+	if SGDB_API_KEY == "" {
+		log.Fatal("Please set the SGDB_API_KEY environment variable with your StreamGridDB API key")
+	}
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+	q, err := load_quarantine()
+	if err != nil {
+		log.Fatal("An error occurred while loading quarantined games", "err", err)
+	}
+	stats, err := load_strategy_stats()
+	if err != nil {
+		log.Fatal("An error occurred while loading match statistics", "err", err)
+	}
+	m, err := load_manifest()
+	if err != nil {
+		log.Fatal("An error occurred while loading the manifest", "err", err)
+	}
+	cacheDir, err := get_cache_dir()
+	if err != nil {
+		log.Fatal("An error occurred while creating the cache directory", "err", err)
+	}
+
+	s := &server{
+		session: &reviewSession{cur: cur, q: q, stats: stats, manifest: m},
+		thumbs:  new_image_proxy(filepath.Join(cacheDir, THUMBS_DIR), "/thumb/"),
+		covers:  map[string]bool{},
+		diverse: *diverse,
+		token:   rand.Text(),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.serve_index)
+	mux.HandleFunc("GET /review/{slug}", s.serve_candidates)
+	mux.HandleFunc("GET /review/{slug}/{id}", s.serve_covers)
+	mux.HandleFunc("POST /review/{slug}/{id}/accept", s.protect(s.accept))
+	mux.HandleFunc("POST /review/{slug}/{id}/blacklist", s.protect(s.blacklist))
+	mux.HandleFunc("GET /thumb/{key}", s.thumbs.serve)
+
+	log.Info("Serving the review UI", "url", "http://"+*address)
+	if err := http.ListenAndServe(*address, mux); err != nil {
+		log.Fatal("An error occurred while serving the review UI", "err", err)
+	}
+}
+
+type server struct {
+	session *reviewSession
+	thumbs  *imageProxy
+	diverse bool
+	// token is put in the forms and required by the handlers changing
+	// anything, which other web pages cannot read.
+	token string
+
+	mu sync.Mutex
+	// covers holds the image URLs shown to the user, the only ones accepted
+	// as cover overrides.
+	covers map[string]bool
+}
+
+type coverChoice struct {
+	Url   string
+	Thumb string
+}
+
+var SERVE_TEMPLATES = template.Must(template.New("").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Lutris cover art review</title>
+<style>body{font-family:sans-serif;margin:1em}img{width:150px;margin:4px}form{display:inline}</style>
+</head><body>{{end}}
+
+{{define "index"}}{{template "head"}}<h1>Quarantined games</h1>
+<p>Group by: <a href="/">nothing</a>{{range .GroupBy}} <a href="/?group-by={{.}}">{{.}}</a>{{end}}</p>
+{{range .Groups}}{{if .Name}}<h2>{{.Name}}</h2>{{end}}
+{{range .Items}}<p><a href="/review/{{.Slug}}">{{.Game.Name}}</a> <code>{{.Slug}}</code> ({{.Reason}}, {{len .Candidates}} candidates)</p>
+{{end}}{{end}}{{if not .Count}}<p>No quarantined games to review.</p>{{end}}
+</body></html>{{end}}
+
+{{define "candidates"}}{{template "head"}}<p><a href="/">Back</a></p><h1>{{.Slug}}</h1>
+{{range .Candidates}}<p><a href="/review/{{$.Slug}}/{{.Game.Id}}">{{.Game.Name}}</a> #{{.Game.Id}}, {{percent .Confidence}} via {{.Strategy}}</p>
+{{end}}</body></html>{{end}}
+
+{{define "covers"}}{{template "head"}}<p><a href="/review/{{.Slug}}">Back</a></p><h1>{{.Slug}} → {{.Name}}</h1>
+<form method="post" action="/review/{{.Slug}}/{{.Id}}/accept"><input type="hidden" name="token" value="{{.Token}}"><button>Accept with the default cover</button></form>
+<form method="post" action="/review/{{.Slug}}/{{.Id}}/blacklist"><input type="hidden" name="token" value="{{.Token}}"><button>Blacklist</button></form>
+<div>{{range .Covers}}<form method="post" action="/review/{{$.Slug}}/{{$.Id}}/accept"><input type="hidden" name="token" value="{{$.Token}}"><input type="hidden" name="cover" value="{{.Url}}"><button><img src="{{.Thumb}}" alt=""></button></form>{{end}}</div>
+</body></html>{{end}}
+`))
+
+func (s *server) serve_index(w http.ResponseWriter, r *http.Request) {
+	groupBy := r.FormValue("group-by")
+	if err := check_group_by(groupBy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.render(w, "index", map[string]any{
+		"GroupBy": GROUP_BY,
+		"Groups":  s.session.q.listing(groupBy),
+		"Count":   len(s.session.q.sorted_items()),
+	})
+}
+
+func (s *server) serve_candidates(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.session.q.item(gameSlug(r.PathValue("slug")))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.render(w, "candidates", item)
+}
+
+func (s *server) serve_covers(w http.ResponseWriter, r *http.Request) {
+	item, c, ok := s.candidate(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	cover, _ := asset_kind(ASSET_COVER)
+	grids, err := fetch_steamgriddb_grids(c.Game.Id)
+	if err != nil {
+		log.Warn("Error while retrieving SteamGridDB grids", "game", item.Slug, "err", err)
+	}
+	matching := images_for_kind(grids, cover)
+	if s.diverse {
+		matching = diversify(matching)
+	}
+	thumbs := grid_thumbs(matching)
+	var covers []coverChoice
+	s.mu.Lock()
+	for i, img := range matching {
+		s.covers[img.Url] = true
+		covers = append(covers, coverChoice{Url: img.Url, Thumb: s.thumbs.register(thumbs[i])})
+	}
+	s.mu.Unlock()
+	s.render(w, "covers", map[string]any{"Slug": item.Slug, "Id": c.Game.Id, "Name": c.Game.Name, "Covers": covers, "Token": s.token})
+}
+
+// protect refuses the requests made by other web pages, which cannot know
+// the token of the forms, and those a browser says come from another site.
+func (s *server) protect(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin request", http.StatusForbidden)
+				return
+			}
+		}
+		if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(s.token)) != 1 {
+			http.Error(w, "invalid token, reload the page", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *server) accept(w http.ResponseWriter, r *http.Request) {
+	item, c, ok := s.candidate(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if coverUrl := r.FormValue("cover"); coverUrl != "" {
+		s.mu.Lock()
+		offered := s.covers[coverUrl]
+		s.mu.Unlock()
+		if !offered {
+			http.Error(w, "unknown cover", http.StatusBadRequest)
+			return
+		}
+		s.session.cur.override(item.Slug, ASSET_COVER, coverUrl)
+	}
+	s.session.accept(item.Slug, c)
+	log.Info("Match accepted", "game", item.Slug, "candidate", c.Game.Name)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *server) blacklist(w http.ResponseWriter, r *http.Request) {
+	item, c, ok := s.candidate(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.session.cur.blacklist(item.Slug, c.Game.Id)
+	if s.session.q.drop_candidate(item.Slug, c.Game.Id) == 0 {
+		log.Warn("Every candidate was blacklisted, the next run will search again", "game", item.Slug)
+		s.session.q.remove(item.Slug)
+	}
+	s.session.save()
+	http.Redirect(w, r, "/review/"+string(item.Slug), http.StatusSeeOther)
+}
+
+func (s *server) candidate(r *http.Request) (quarantineItem, candidate, bool) {
+	item, ok := s.session.q.item(gameSlug(r.PathValue("slug")))
+	if !ok {
+		return item, candidate{}, false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return item, candidate{}, false
+	}
+	idx := slices.IndexFunc(item.Candidates, func(c candidate) bool { return c.Game.Id == id })
+	if idx < 0 {
+		return item, candidate{}, false
+	}
+	return item, item.Candidates[idx], true
+}
+
+func (s *server) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := SERVE_TEMPLATES.ExecuteTemplate(w, name, data); err != nil {
+		log.Error("Error while rendering page", "page", name, "err", err)
+	}
+}
+
+// imageProxy serves images from a read-through disk cache, under the hash of
+// their URL. It only fetches the URLs the server handed out itself, so it
+// cannot be used to fetch arbitrary URLs, but serves anything already cached.
+type imageProxy struct {
+	dir   string
+	route string
+
+	mu   sync.Mutex
+	urls map[string]string
+}
+
+func new_image_proxy(dir, route string) *imageProxy {
+	return &imageProxy{dir: dir, route: route, urls: map[string]string{}}
+}
+
+func (p *imageProxy) register(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	key := hex.EncodeToString(sum[:])
+	p.mu.Lock()
+	p.urls[key] = u
+	p.mu.Unlock()
+	return p.route + key
+}
+
+func (p *imageProxy) serve(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, err := hex.DecodeString(key); err != nil || len(key) != sha256.Size*2 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=86400")
+	file := filepath.Join(p.dir, key)
+	if data, err := os.ReadFile(file); err == nil {
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+		w.Write(data)
+		return
+	}
+	p.mu.Lock()
+	u, ok := p.urls[key]
+	p.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := http_get(r.Context(), u, false)
+	if err != nil {
+		log.Warn("Error while proxying image", "url", u, "err", err)
+		http.Error(w, "image unavailable", http.StatusBadGateway)
+		return
+	}
+	if err := write_cache_file(file, data); err != nil {
+		log.Warn("Error while caching image", "url", u, "err", err)
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Write(data)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+)
+
+const SOURCE_FLAG = "flag"
+const SOURCE_ENV = "environment"
+const SOURCE_PROFILE = "profile"
+const SOURCE_CONFIG = "config file"
+const SOURCE_DEFAULT = "default"
+
+// setting is a value that can come from several sources, the command line
+// winning over the environment, then the selected profile, then the rest of
+// the config file, then the default.
+type setting struct {
+	// Name is the key of the setting in the config file and its profiles.
+	Name    string
+	Flag    string
+	Env     string
+	Default string
+	// Secret settings are not printed by `config show --effective` nor in
+	// crash reports.
+	Secret bool
+	// Apply sets the value for the commands without the flag.
+	Apply func(string) error
+}
+
+var SETTINGS = []setting{
+	{Name: "api_url", Flag: "api-url", Env: "SGDB_API_URL", Default: SGDB_DEFAULT_API_URL, Apply: set_sgdb_api_url},
+	{Name: "lutris_version", Flag: "lutris-version", Env: "LUTRIS_VERSION", Apply: set_lutris_version},
+	{Name: "assets", Flag: "assets", Default: DEFAULT_ASSETS},
+	{Name: "runner_assets"},
+	{Name: "tool_catalog"},
+	{Name: "workers", Flag: "workers", Default: strconv.Itoa(DEFAULT_WORKERS)},
+	{Name: "resize", Flag: "resize", Default: "false"},
+	{Name: "transcode", Flag: "transcode"},
+	{Name: "provenance", Flag: "provenance"},
+	{Name: "steam_art", Flag: "steam-art", Default: "false"},
+	{Name: "required_assets", Flag: "required-assets"},
+	{Name: "locale", Env: "LUTRIS_COVER_ART_LOCALE"},
+	{Name: "hardened", Flag: "hardened", Env: "LUTRIS_COVER_ART_HARDENED", Default: "false"},
+	{Name: "archives_kept", Default: strconv.Itoa(DEFAULT_ARCHIVES_KEPT)},
+	{Name: "smtp_host"},
+	{Name: "smtp_port", Default: "587"},
+	{Name: "smtp_username"},
+	{Name: "smtp_password", Env: "SMTP_PASSWORD", Secret: true},
+	{Name: "smtp_from"},
+	{Name: "smtp_to"},
+}
+
+// resolvedSetting is the value a setting ends up with and where it came from.
+type resolvedSetting struct {
+	Value  string
+	Source string
+}
+
+// effectiveSettings are resolved by parse_flags, once per run.
+var effectiveSettings map[string]resolvedSetting
+
+// parse_flags parses the command line and fills in every setting it does not
+// give from the other sources. Every command parses its flags with it, so
+// every command takes --config-profile and --hardened.
+func parse_flags(fs *flag.FlagSet, args []string) {
+	profile := fs.String("config-profile", "", "profile of the config file to use, its settings overriding the rest of the file")
+	hardened := fs.Bool("hardened", false, "restrict the process to the Lutris directories, the directories of the tool, the output files and the network, with Landlock and seccomp where available")
+	fs.Parse(args)
+
+	cfg, err := load_config()
+	if err != nil {
+		log.Fatal("An error occurred while loading the configuration", "err", err)
+	}
+	effectiveSettings, err = resolve_settings(cfg, *profile, fs)
+	if err != nil {
+		log.Fatal("Invalid --config-profile value", "err", err)
+	}
+	for _, s := range SETTINGS {
+		resolved := effectiveSettings[s.Name]
+		if resolved.Source == SOURCE_FLAG || resolved.Source == SOURCE_DEFAULT {
+			continue
+		}
+		if f := fs.Lookup(s.Flag); s.Flag != "" && f != nil {
+			// not going through fs.Set, which would make it look given on
+			// the command line, e.g. to --assets overriding runner_assets
+			err = f.Value.Set(resolved.Value)
+		} else if s.Apply != nil {
+			err = s.Apply(resolved.Value)
+		}
+		if err != nil {
+			log.Fatal("Invalid "+s.Name+" setting", "source", resolved.Source, "err", err)
+		}
+	}
+	if *hardened {
+		harden(fs)
+	}
+}
+
+// resolve_settings goes through the sources of every setting in order of
+// precedence, fs being nil when there is no command line to look at.
+func resolve_settings(cfg configFile, profile string, fs *flag.FlagSet) (map[string]resolvedSetting, error) {
+	values := cfg.Profiles[profile]
+	if profile != "" && values == nil {
+		return nil, fmt.Errorf("no profile %q in %s", profile, cfg.Path)
+	}
+	for name := range cfg.Values {
+		if !slices.ContainsFunc(SETTINGS, func(s setting) bool { return s.Name == name }) {
+			log.Warn("Unknown setting in the configuration", "setting", name, "file", cfg.Path)
+		}
+	}
+	resolved := map[string]resolvedSetting{}
+	for _, s := range SETTINGS {
+		switch raw, inProfile := values[s.Name]; {
+		case s.Flag != "" && fs != nil && flag_set(fs, s.Flag):
+			resolved[s.Name] = resolvedSetting{fs.Lookup(s.Flag).Value.String(), SOURCE_FLAG}
+		case s.Env != "" && os.Getenv(s.Env) != "":
+			resolved[s.Name] = resolvedSetting{os.Getenv(s.Env), SOURCE_ENV + " " + s.Env}
+		case inProfile:
+			resolved[s.Name] = resolvedSetting{config_value(raw), SOURCE_PROFILE + " " + profile}
+		case cfg.Values[s.Name] != nil:
+			resolved[s.Name] = resolvedSetting{config_value(cfg.Values[s.Name]), SOURCE_CONFIG}
+		default:
+			resolved[s.Name] = resolvedSetting{s.Default, SOURCE_DEFAULT}
+		}
+	}
+	return resolved, nil
+}
+
+// display hides the value of secret settings.
+func (s setting) display(value string) string {
+	if s.Secret && value != "" {
+		return "********"
+	}
+	return value
+}
+
+// config_value turns a JSON value of the config file into what the flag of
+// the setting would take, strings unquoted, anything else as written.
+func config_value(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var compact bytes.Buffer
+	if json.Compact(&compact, raw) != nil {
+		return string(raw)
+	}
+	return compact.String()
+}
+
+// setting_value returns the effective value of a setting, resolving the
+// settings without a command line when no flags were parsed.
+func setting_value(name string) string {
+	if effectiveSettings == nil {
+		cfg, err := load_config()
+		if err != nil {
+			log.Fatal("An error occurred while loading the configuration", "err", err)
+		}
+		if effectiveSettings, err = resolve_settings(cfg, "", nil); err != nil {
+			log.Fatal("An error occurred while loading the configuration", "err", err)
+		}
+	}
+	return effectiveSettings[name].Value
+}
+
+func run_config(args []string) {
+	if len(args) == 0 || args[0] != "show" {
+		log.Fatal("Usage: config show [--effective] [--config-profile <name>]")
+	}
+	fs := flag.NewFlagSet("config show", flag.ExitOnError)
+	effective := fs.Bool("effective", false, "print the value every setting ends up with, merging the environment, the profile, the config file and the defaults, and where it came from")
+	parse_flags(fs, args[1:])
+
+	if !*effective {
+		cfg, err := load_config()
+		if err != nil {
+			log.Fatal("An error occurred while loading the configuration", "err", err)
+		}
+		data, err := os.ReadFile(cfg.Path)
+		if err != nil {
+			log.Info("No config file", "file", cfg.Path)
+			return
+		}
+		fmt.Printf("# %s\n%s", cfg.Path, data)
+		return
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "SETTING\tVALUE\tSOURCE")
+	for _, s := range SETTINGS {
+		resolved := effectiveSettings[s.Name]
+		fmt.Fprintf(out, "%s\t%s\t%s\n", s.Name, s.display(resolved.Value), resolved.Source)
+	}
+	out.Flush()
+	fmt.Println("\nFlags given to a command override all of these.")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// the ASCII characters Python's \s matches, which str.strip() also removes
+const PYTHON_ASCII_SPACES = " \t\n\r\f\v\x1c\x1d\x1e\x1f"
+
+var (
+	SLUG_REMOVED   = regexp.MustCompile(`[^a-zA-Z0-9_` + PYTHON_ASCII_SPACES + `-]`)
+	SLUG_SEPARATOR = regexp.MustCompile(`[` + PYTHON_ASCII_SPACES + `-]+`)
+)
+
+// UUID_NAMESPACE_URL is uuid.NAMESPACE_URL of Python.
+var UUID_NAMESPACE_URL = [16]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+// lutris_slug is the slug Lutris gives a game of that name, which its art
+// files are named after, following slugify of lutris.util.strings step by
+// step: NFD decomposition, dropping what is not ASCII, removing what is
+// neither a word character, a space nor a hyphen, stripping and lowercasing,
+// and joining words with single hyphens. Punctuation is removed before
+// stripping, so "Game !" becomes "game", and names without a Latin character
+// get a UUID.
+func lutris_slug(name string) gameSlug {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		if r < 0x80 {
+			b.WriteRune(r)
+		}
+	}
+	value := strings.ToLower(strings.Trim(SLUG_REMOVED.ReplaceAllString(b.String(), ""), PYTHON_ASCII_SPACES))
+	slug := SLUG_SEPARATOR.ReplaceAllString(value, "-")
+	if slug == "" {
+		return gameSlug(uuid5(UUID_NAMESPACE_URL, name))
+	}
+	return gameSlug(slug)
+}
+
+// uuid5 is uuid.uuid5 of Python, for the slugs of non-Latin names.
+func uuid5(namespace [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+// the slugs slugify of lutris.util.strings gives these names
+func TestLutrisSlug(t *testing.T) {
+	tests := []struct {
+		name string
+		want gameSlug
+	}{
+		{"Celeste", "celeste"},
+		// accents
+		{"Pokémon Snap", "pokemon-snap"},
+		{"Brütal Legend", "brutal-legend"},
+		{"Ōkami HD", "okami-hd"},
+		{"Ⅻ Zwölf", "zwolf"},
+		{"Æon Flux", "on-flux"},
+		{"ﬁnal ﬁght", "nal-ght"},
+		// trademark symbols and typographic punctuation
+		{"The Witcher® 3: Wild Hunt", "the-witcher-3-wild-hunt"},
+		{"Tom Clancy's Rainbow Six® Siege", "tom-clancys-rainbow-six-siege"},
+		{"Assassin’s Creed™ Unity", "assassins-creed-unity"},
+		{"FINAL FANTASY™ VII", "final-fantasy-vii"},
+		{"DOOM Eternal — Deluxe", "doom-eternal-deluxe"},
+		{"S.T.A.L.K.E.R.: Shadow of Chernobyl", "stalker-shadow-of-chernobyl"},
+		{"Baldur's Gate 3 (2023)", "baldurs-gate-3-2023"},
+		// punctuation next to spaces, removed before stripping
+		{"Neon White!!!", "neon-white"},
+		{"Who's Lila?", "whos-lila"},
+		{"Game !", "game"},
+		{"! Game", "game"},
+		{"Game - !", "game-"},
+		{"Grand Theft Auto V_", "grand-theft-auto-v_"},
+		// repeated whitespace and hyphens
+		{"  Hollow   Knight  ", "hollow-knight"},
+		{"Tab\tSeparated", "tab-separated"},
+		{"Spider-Man -- Remastered", "spider-man-remastered"},
+		{"Crash Bandicoot - N. Sane Trilogy", "crash-bandicoot-n-sane-trilogy"},
+		// nothing Latin left, uuid5 of the name in the URL namespace
+		{"原神", "acef78fe-da28-5bba-b717-5dec1a439152"},
+		{"東方紅魔郷", "6ad328f1-1cb6-535b-8900-d10d3d2d62cf"},
+		{"Ведьмак", "a69f8651-ba62-52ee-afef-6a02ad093a0a"},
+		{"™", "62090136-1413-5465-ac9a-a32a05cf96e3"},
+	}
+	for _, tt := range tests {
+		if got := lutris_slug(tt.name); got != tt.want {
+			t.Errorf("lutris_slug(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
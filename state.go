@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+)
+
+const STATE_DIR_NAME = "lutris-cover-art-fetcher"
+
+func get_state_dir() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateHome = filepath.Join(homeDir, ".local", "state")
+	}
+	dir := filepath.Join(stateHome, STATE_DIR_NAME)
+	return dir, os.MkdirAll(dir, 0o755)
+}
+
+// get_cache_dir holds files that can be downloaded again, unlike the state.
+func get_cache_dir() (string, error) {
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if cacheHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		cacheHome = filepath.Join(homeDir, ".cache")
+	}
+	dir := filepath.Join(cacheHome, STATE_DIR_NAME)
+	return dir, os.MkdirAll(dir, 0o755)
+}
+
+// read_state_file leaves v untouched when the file does not exist yet.
+func read_state_file(name string, v any) error {
+	dir, err := get_state_dir()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// remove_state_file only logs errors, a leftover file being harmless.
+func remove_state_file(name string) {
+	dir, err := get_state_dir()
+	if err == nil {
+		err = os.Remove(filepath.Join(dir, name))
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn("Error while removing state file", "file", name, "err", err)
+	}
+}
+
+func write_state_file(name string, v any) error {
+	dir, err := get_state_dir()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// write_cache_file creates the directory of the file when needed.
+func write_cache_file(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return copy_to_file(bytes.NewReader(data), file)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+)
+
+const STATS_FILE = "stats.json"
+
+// strategyStats counts, across runs, how often each match strategy was tried
+// and how often it produced the accepted match.
+type strategyStats struct {
+	mu sync.Mutex
+
+	Strategies map[string]*strategyCounters `json:"strategies"`
+}
+
+type strategyCounters struct {
+	Attempts int `json:"attempts"`
+	Wins     int `json:"wins"`
+}
+
+func load_strategy_stats() (*strategyStats, error) {
+	s := &strategyStats{}
+	err := read_state_file(STATS_FILE, s)
+	if s.Strategies == nil {
+		s.Strategies = map[string]*strategyCounters{}
+	}
+	return s, err
+}
+
+func (s *strategyStats) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return write_state_file(STATS_FILE, s)
+}
+
+func (s *strategyStats) counters(strategy string) *strategyCounters {
+	if s.Strategies[strategy] == nil {
+		s.Strategies[strategy] = &strategyCounters{}
+	}
+	return s.Strategies[strategy]
+}
+
+func (s *strategyStats) attempt(strategy string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters(strategy).Attempts++
+}
+
+func (s *strategyStats) win(strategy string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters(strategy).Wins++
+}
+
+func run_stats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	parse_flags(fs, args)
+
+	stats, err := load_strategy_stats()
+	if err != nil {
+		log.Fatal("An error occurred while loading match statistics", "err", err)
+	}
+	m, err := load_manifest()
+	if err != nil {
+		log.Fatal("An error occurred while loading the manifest", "err", err)
+	}
+	library := map[string]int{}
+	for _, g := range m.Games {
+		library[g.Strategy]++
+	}
+
+	names := make([]string, 0, len(stats.Strategies))
+	for name := range stats.Strategies {
+		names = append(names, name)
+	}
+	for name := range library {
+		if stats.Strategies[name] == nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		log.Info("No match recorded yet")
+		return
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return stats.counters(names[i]).Wins > stats.counters(names[j]).Wins
+	})
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "STRATEGY\tATTEMPTS\tWINS\tWIN RATE\tGAMES\tDESCRIPTION")
+	for _, name := range names {
+		c := stats.counters(name)
+		rate := "-"
+		if c.Attempts > 0 {
+			rate = fmt.Sprintf("%.0f%%", 100*float64(c.Wins)/float64(c.Attempts))
+		}
+		fmt.Fprintf(out, "%s\t%d\t%d\t%s\t%d\t%s\n", name, c.Attempts, c.Wins, rate, library[name], describe_strategy(name))
+	}
+	out.Flush()
+}
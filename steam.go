@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const PROVIDER_STEAM = "steam"
+
+// STEAM_DIRS are where native, Debian and Flatpak installs of Steam keep
+// their files, relative to the home directory.
+var STEAM_DIRS = []string{
+	".local/share/Steam",
+	".steam/steam",
+	".steam/debian-installation",
+	".var/app/com.valvesoftware.Steam/.local/share/Steam",
+}
+
+// STEAM_LIBRARY_IMAGES are the files of the Steam library cache matching each
+// asset type, Steam having nothing the size of Lutris banners.
+var STEAM_LIBRARY_IMAGES = map[assetType][]string{
+	ASSET_COVER: {"library_600x900_2x.jpg", "library_600x900.jpg"},
+	ASSET_HERO:  {"library_hero.jpg"},
+	ASSET_LOGO:  {"logo.png"},
+}
+
+var ACF_APPID = regexp.MustCompile(`"appid"\s+"(\d+)"`)
+var ACF_NAME = regexp.MustCompile(`"name"\s+"([^"]*)"`)
+var VDF_PATH = regexp.MustCompile(`"path"\s+"([^"]*)"`)
+
+// steamLibrary is the art a local Steam install already downloaded for the
+// games of its library, read from disk for free.
+type steamLibrary struct {
+	cacheDir string
+	// appIds maps the normalized lowercased name of the installed Steam
+	// games to their app ID, for the games Lutris has from other stores.
+	appIds map[string]string
+}
+
+// load_steam_library returns nil when there is no Steam install.
+func load_steam_library() (*steamLibrary, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range STEAM_DIRS {
+		steamDir := filepath.Join(homeDir, dir)
+		cacheDir := filepath.Join(steamDir, "appcache", "librarycache")
+		if _, err := os.Stat(cacheDir); err != nil {
+			continue
+		}
+		return &steamLibrary{cacheDir: cacheDir, appIds: steam_app_ids(steamDir)}, nil
+	}
+	return nil, nil
+}
+
+// steam_app_ids reads the app manifests of every Steam library folder.
+func steam_app_ids(steamDir string) map[string]string {
+	folders := []string{steamDir}
+	if vdf, err := os.ReadFile(filepath.Join(steamDir, "steamapps", "libraryfolders.vdf")); err == nil {
+		for _, match := range VDF_PATH.FindAllStringSubmatch(string(vdf), -1) {
+			folders = append(folders, strings.ReplaceAll(match[1], `\\`, `\`))
+		}
+	}
+	appIds := map[string]string{}
+	for _, folder := range folders {
+		manifests, _ := filepath.Glob(filepath.Join(folder, "steamapps", "appmanifest_*.acf"))
+		for _, manifest := range manifests {
+			data, err := os.ReadFile(manifest)
+			if err != nil {
+				continue
+			}
+			appId, name := ACF_APPID.FindSubmatch(data), ACF_NAME.FindSubmatch(data)
+			if appId != nil && name != nil {
+				appIds[strings.ToLower(normalize_name(string(name[1])))] = string(appId[1])
+			}
+		}
+	}
+	return appIds
+}
+
+func (s *steamLibrary) app_id(g game) string {
+	if g.Service == SERVICE_STEAM && g.ServiceId != "" {
+		return g.ServiceId
+	}
+	return s.appIds[strings.ToLower(normalize_name(g.Name))]
+}
+
+// image returns the file Steam has for the asset of the game, in the flat
+// <appid>_<file> layout of older clients or the <appid>/ directories of
+// newer ones, which may nest the file one level deeper.
+func (s *steamLibrary) image(g game, kind assetKind) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	appId := s.app_id(g)
+	if appId == "" {
+		return "", false
+	}
+	for _, name := range STEAM_LIBRARY_IMAGES[kind.Name] {
+		candidates := []string{
+			filepath.Join(s.cacheDir, appId+"_"+name),
+			filepath.Join(s.cacheDir, appId, name),
+		}
+		nested, _ := filepath.Glob(filepath.Join(s.cacheDir, appId, "*", name))
+		for _, file := range append(candidates, nested...) {
+			if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+				return file, true
+			}
+		}
+	}
+	return "", false
+}
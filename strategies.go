@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// matchStrategy is one way of finding a game on SteamGridDB. Strategies are
+// tried in order until one gives a confident and unambiguous match.
+type matchStrategy struct {
+	Name    string
+	Applies func(g game) bool
+	Search  func(ctx context.Context, g game) ([]gameData, error)
+}
+
+var MATCH_STRATEGIES = []matchStrategy{
+	{Name: "store-id", Applies: has_store_id, Search: search_by_store_id},
+	{Name: "name-search", Applies: has_name, Search: search_by_name},
+	{Name: "normalized-name", Applies: has_normalized_name, Search: search_by_normalized_name},
+	{Name: "transliterated-name", Applies: has_transliterated_name, Search: search_by_transliterated_name},
+	{Name: "slug-search", Applies: has_distinct_slug, Search: search_by_slug},
+	{Name: "igdb-names", Applies: has_igdb_names, Search: search_by_igdb_names},
+}
+
+// SGDB_STORE_PLATFORMS maps Lutris services to the platforms SteamGridDB can
+// look games up by.
+var SGDB_STORE_PLATFORMS = map[gameService]string{
+	SERVICE_STEAM:   "steam",
+	SERVICE_EGS:     "egs",
+	SERVICE_ORIGIN:  "origin",
+	SERVICE_EA_APP:  "origin",
+	SERVICE_UBISOFT: "uplay",
+}
+
+func has_store_id(g game) bool {
+	return g.ServiceId != "" && SGDB_STORE_PLATFORMS[g.Service] != ""
+}
+
+func search_by_store_id(ctx context.Context, g game) ([]gameData, error) {
+	u, err := sgdb_url("games", SGDB_STORE_PLATFORMS[g.Service], g.ServiceId)
+	if err != nil {
+		return nil, err
+	}
+	var found gameData
+	err = sgdb_get_json(ctx, u.String(), &found)
+	if is_not_found(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if found.Id == 0 {
+		return nil, nil
+	}
+	return []gameData{found}, nil
+}
+
+func has_name(g game) bool {
+	return g.Name != ""
+}
+
+func search_by_name(ctx context.Context, g game) ([]gameData, error) {
+	return search_steamgriddb_games(ctx, g.Name)
+}
+
+// EDITION_SUFFIX matches the edition names stores append to titles, which
+// SteamGridDB usually lists under the base game.
+var EDITION_SUFFIX = regexp.MustCompile(`(?i)[\s:\-–(]*\b(goty|game of the year|deluxe|complete|definitive|enhanced|ultimate|gold|premium|standard|digital deluxe)\b( edition)?\)?\s*$`)
+var TRADEMARK_SIGNS = strings.NewReplacer("™", "", "®", "", "©", "")
+
+func normalize_name(name string) string {
+	normalized := TRADEMARK_SIGNS.Replace(name)
+	for {
+		stripped := strings.TrimSpace(EDITION_SUFFIX.ReplaceAllString(normalized, ""))
+		if stripped == normalized || stripped == "" {
+			return normalized
+		}
+		normalized = stripped
+	}
+}
+
+func has_normalized_name(g game) bool {
+	return g.Name != "" && normalize_name(g.Name) != g.Name
+}
+
+func search_by_normalized_name(ctx context.Context, g game) ([]gameData, error) {
+	return search_steamgriddb_games(ctx, normalize_name(g.Name))
+}
+
+func has_transliterated_name(g game) bool {
+	return needs_transliteration(g.Name) && transliterate(g.Name) != g.Name
+}
+
+func search_by_transliterated_name(ctx context.Context, g game) ([]gameData, error) {
+	return search_steamgriddb_games(ctx, transliterate(g.Name))
+}
+
+func has_distinct_slug(g game) bool {
+	return string(g.Slug) != g.Name
+}
+
+func search_by_slug(ctx context.Context, g game) ([]gameData, error) {
+	return search_steamgriddb_games(ctx, string(g.Slug))
+}
+
+// has_igdb_names needs IGDB credentials, which are optional.
+func has_igdb_names(g game) bool {
+	return g.Name != "" && igdb.clientId != ""
+}
+
+// search_by_igdb_names searches SteamGridDB, which is English-centric, by the
+// names IGDB knows the game under, e.g. the English title of a game added
+// with its Japanese one.
+func search_by_igdb_names(ctx context.Context, g game) ([]gameData, error) {
+	names, err := igdb.alternative_names(ctx, g.Name)
+	if err != nil {
+		return nil, err
+	}
+	var found []gameData
+	for _, name := range names {
+		games, err := search_steamgriddb_games(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, data := range games {
+			if !slices.ContainsFunc(found, func(f gameData) bool { return f.Id == data.Id }) {
+				found = append(found, data)
+			}
+		}
+	}
+	return found, nil
+}
+
+// strategy_confidence trusts store IDs blindly, anything else is scored on
+// how close the names are.
+func strategy_confidence(strategy string, g game, data gameData) float64 {
+	if strategy == "store-id" {
+		return 1
+	}
+	confidence := max(match_confidence(g.Name, data.Name), match_confidence(string(g.Slug), data.Name))
+	if strategy == "normalized-name" {
+		confidence = max(confidence, match_confidence(normalize_name(g.Name), data.Name))
+	}
+	if strategy == "igdb-names" {
+		for _, name := range igdb.cached_alternative_names(g.Name) {
+			confidence = max(confidence, match_confidence(name, data.Name))
+		}
+	}
+	return confidence
+}
+
+func describe_strategy(strategy string) string {
+	switch strategy {
+	case "store-id":
+		return "SteamGridDB lookup by the store ID of the game"
+	case "name-search":
+		return "search by the game name"
+	case "normalized-name":
+		return "search by the game name without edition suffixes"
+	case "transliterated-name":
+		return "search by the game name transliterated to ASCII"
+	case "slug-search":
+		return "search by the Lutris slug"
+	case "igdb-names":
+		return "search by the names IGDB knows the game under"
+	case "manual-search":
+		return "search terms typed during interactive review"
+	}
+	return fmt.Sprintf("unknown strategy %q", strategy)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	OUTCOME_FETCHED = "fetched"
+	OUTCOME_SKIPPED = "skipped"
+	OUTCOME_FAILED  = "failed"
+)
+
+// runSummary collects the games that need a manual look once the run is over,
+// along with per asset type counters.
+type runSummary struct {
+	mu        sync.Mutex
+	Unmatched []summaryEntry
+	Ambiguous []summaryEntry
+	Skipped   []summaryEntry
+	Failed    []summaryEntry
+	Assets    map[assetType]map[string]int
+	// Fetched lists the assets written, for the run history.
+	Fetched []fetchedEntry
+}
+
+type summaryEntry struct {
+	Slug   gameSlug `json:"slug"`
+	Name   string   `json:"name"`
+	Code   string   `json:"code"`
+	Reason string   `json:"reason"`
+}
+
+type fetchedEntry struct {
+	Slug  gameSlug  `json:"slug"`
+	Name  string    `json:"name"`
+	Asset assetType `json:"asset"`
+}
+
+func new_run_summary() *runSummary {
+	return &runSummary{Assets: map[assetType]map[string]int{}}
+}
+
+func (s *runSummary) count(kind assetKind, outcome string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Assets[kind.Name] == nil {
+		s.Assets[kind.Name] = map[string]int{}
+	}
+	s.Assets[kind.Name][outcome]++
+}
+
+func (s *runSummary) add_fetched(g game, kind assetKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Fetched = append(s.Fetched, fetchedEntry{g.Slug, g.Name, kind.Name})
+}
+
+func (s *runSummary) add_unmatched(g game, code, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Unmatched = append(s.Unmatched, summaryEntry{g.Slug, g.Name, code, reason})
+}
+
+func (s *runSummary) add_ambiguous(g game, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Ambiguous = append(s.Ambiguous, summaryEntry{g.Slug, g.Name, E_AMBIGUOUS, reason})
+}
+
+func (s *runSummary) add_skipped(g game, code, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Skipped = append(s.Skipped, summaryEntry{g.Slug, g.Name, code, reason})
+}
+
+func (s *runSummary) add_failed(g game, code, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Failed = append(s.Failed, summaryEntry{g.Slug, g.Name, code, reason})
+}
+
+// codes counts the entries of the summary by error code.
+func (s *runSummary) codes() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return count_codes(s.Unmatched, s.Ambiguous, s.Skipped, s.Failed)
+}
+
+func count_codes(entries ...[]summaryEntry) map[string]int {
+	codes := map[string]int{}
+	for _, e := range slices.Concat(entries...) {
+		codes[e.Code]++
+	}
+	return codes
+}
+
+func (s *runSummary) print(kinds []assetKind, dryRun bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fetched := "fetched"
+	if dryRun {
+		fetched = "would fetch"
+	}
+	for _, kind := range kinds {
+		counts := s.Assets[kind.Name]
+		log.Info(kind.Name, fetched, counts[OUTCOME_FETCHED], "skipped", counts[OUTCOME_SKIPPED], "failed", counts[OUTCOME_FAILED])
+	}
+	if hits, misses := sgdbImages.stats(); hits+misses > 0 {
+		log.Info("image lists", "cached", hits, "requested", misses)
+	}
+
+	if len(s.Unmatched)+len(s.Ambiguous)+len(s.Skipped)+len(s.Failed) == 0 {
+		return
+	}
+	log.Info(fmt.Sprintf("%d unmatched, %d ambiguous, %d skipped, %d failed", len(s.Unmatched), len(s.Ambiguous), len(s.Skipped), len(s.Failed)))
+	log.Info("by code", code_counts(count_codes(s.Unmatched, s.Ambiguous, s.Skipped, s.Failed))...)
+	print_summary_entries("Unmatched", s.Unmatched)
+	if dryRun {
+		print_summary_entries("Would quarantine", s.Ambiguous)
+	} else {
+		print_summary_entries("Quarantined", s.Ambiguous)
+	}
+	print_summary_entries("Skipped", s.Skipped)
+	print_summary_entries("Failed", s.Failed)
+}
+
+func print_summary_entries(title string, entries []summaryEntry) {
+	for _, e := range entries {
+		log.Warn(title, "game", e.Slug, "name", e.Name, "code", e.Code, "reason", e.Reason)
+	}
+}
+
+// code_counts are key-value pairs for the logger, in the order of
+// ERROR_CODES.
+func code_counts(codes map[string]int) []any {
+	sorted := slices.SortedFunc(maps.Keys(codes), func(a, b string) int {
+		return cmp.Compare(error_code_index(a), error_code_index(b))
+	})
+	var pairs []any
+	for _, code := range sorted {
+		pairs = append(pairs, code, codes[code])
+	}
+	return pairs
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/image/draw"
+)
+
+// BANNER_BACKDROP_SCALE is how much the cover is shrunk before being blown up
+// again behind the banner, blurring it.
+const BANNER_BACKDROP_SCALE = 24
+
+// BANNER_BACKDROP_SHADE darkens the backdrop so the cover stands out.
+var BANNER_BACKDROP_SHADE = color.NRGBA{A: 112}
+
+// run_synthesize makes the missing art of games out of the art they already
+// have, without any network access, for offline or air-gapped setups. The
+// art it makes is recorded as a fallback, a later fetch replacing it once
+// the APIs can be reached.
+func run_synthesize(args []string) {
+	fs := flag.NewFlagSet("synthesize", flag.ExitOnError)
+	add_lutris_version_flag(fs)
+	bannersFromCovers := fs.Bool("banners-from-covers", false, "make a banner for the games having a cover but no banner")
+	where := fs.String("where", "", "only synthesize art for the games matching this expression")
+	dryRun := fs.Bool("dry-run", false, "only list the games art would be made for")
+	parse_flags(fs, args)
+	if !*bannersFromCovers || fs.NArg() > 0 {
+		log.Fatal("Usage: synthesize --banners-from-covers")
+	}
+	filter, err := parse_game_filter(*where)
+	if err != nil {
+		log.Fatal("Invalid --where value", "err", err)
+	}
+
+	lutrisDirs, err := get_lutris_dir()
+	if err != nil {
+		log.Fatal("An error occurred while retrieving Lutris directories", "err", err)
+	}
+	db, err := connect_to_lutris_db(lutrisDirs.DbFilePath)
+	if err != nil {
+		log.Fatal("An error occurred while connecting to Lutris database", "err", err)
+	}
+	defer db.Close()
+	lutrisDirs = apply_lutris_compat(db, lutrisDirs, true)
+	games, err := load_library(db, librarySource{})
+	if err != nil {
+		log.Fatal("An error occurred while fetching installed games", "err", err)
+	}
+	m, err := load_manifest()
+	if err != nil {
+		log.Fatal("An error occurred while loading the manifest", "err", err)
+	}
+
+	cover, _ := asset_kind(ASSET_COVER)
+	banner, _ := asset_kind(ASSET_BANNER)
+	made, failed := 0, 0
+	for _, g := range filter.filter(games) {
+		covers := asset_files(lutrisDirs, cover, g.Slug)
+		if len(covers) == 0 || !asset_missing(lutrisDirs, banner, g.Slug) {
+			continue
+		}
+		if *dryRun {
+			log.Info("Would make a banner from the cover", "game", g.Slug, "cover", covers[0])
+			made++
+			continue
+		}
+		if err := banner_from_cover(lutrisDirs, m, g, banner, covers[0]); err != nil {
+			log.Error("Error while making a banner from the cover", "game", g.Slug, "code", error_code(err), "err", err)
+			failed++
+			continue
+		}
+		log.Info("Banner made from the cover", "game", g.Slug)
+		made++
+	}
+
+	if *dryRun {
+		log.Info(fmt.Sprintf("%d banners would be made", made))
+		return
+	}
+	if err := m.save(); err != nil {
+		log.Error("Error while saving the manifest", "err", err)
+	}
+	log.Info(fmt.Sprintf("%d banners made, %d failed", made, failed))
+}
+
+func banner_from_cover(dirs lutrisDirs, m *manifest, g game, kind assetKind, coverFile string) error {
+	data, err := os.ReadFile(coverFile)
+	if err != nil {
+		return err
+	}
+	cover, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return with_code(E_BAD_IMAGE, fmt.Errorf("decoding image: %w", err))
+	}
+	encoded, err := encode_image(compose_banner(cover, kind.TargetWidth, kind.TargetHeight), MIME_TYPE_JPEG)
+	if err != nil {
+		return with_code(E_BAD_IMAGE, fmt.Errorf("encoding image: %w", err))
+	}
+	source := "file://" + coverFile
+	staged, err := stage_asset(dirs, kind, g.Slug, encoded, MIME_TYPE_JPEG, source, imageProcessing{})
+	if err != nil {
+		return err
+	}
+	if err := staged.commit(); err != nil {
+		return err
+	}
+	m.record_fallback(g.Slug, kind.Name, source)
+	return nil
+}
+
+// compose_banner puts the whole cover in the middle of the banner, over a
+// blurred and darkened blow-up of itself filling the rest.
+func compose_banner(cover image.Image, width, height int) image.Image {
+	// shrinking the cover then scaling it back up is a cheap blur
+	small := resize_to_fill(cover, max(width/BANNER_BACKDROP_SCALE, 1), max(height/BANNER_BACKDROP_SCALE, 1))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Bounds(), small, small.Bounds(), draw.Src, nil)
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(BANNER_BACKDROP_SHADE), image.Point{}, draw.Over)
+
+	src := cover.Bounds()
+	fitWidth, fitHeight := src.Dx()*height/src.Dy(), height
+	if fitWidth > width {
+		fitWidth, fitHeight = width, src.Dy()*width/src.Dx()
+	}
+	fit := image.Rect(0, 0, fitWidth, fitHeight).Add(image.Pt((width-fitWidth)/2, (height-fitHeight)/2))
+	draw.CatmullRom.Scale(dst, fit, cover, src, draw.Over, nil)
+	return dst
+}
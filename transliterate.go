@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// LATIN_LIGATURES covers the letters NFKD does not decompose into ASCII.
+var LATIN_LIGATURES = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "Æ", "AE", "œ", "oe", "Œ", "OE",
+	"ø", "o", "Ø", "O", "ł", "l", "Ł", "L", "đ", "d", "Đ", "D",
+	"ð", "d", "Ð", "D", "þ", "th", "Þ", "Th",
+	"‘", "'", "’", "'", "“", "\"", "”", "\"", "–", "-", "—", "-", "…", "...",
+)
+
+// transliterate turns a title into an ASCII search term SteamGridDB, which is
+// English-centric, has better odds of knowing: diacritics and full-width
+// characters are folded and kana are romanized. Kanji are left alone.
+func transliterate(s string) string {
+	s = romanize_kana(TRADEMARK_SIGNS.Replace(s))
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(LATIN_LIGATURES.Replace(s)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func needs_transliteration(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return true
+		}
+	}
+	return false
+}
+
+// KANA_DIGRAPHS lists the combinations of a kana with a small ya/yu/yo.
+var KANA_DIGRAPHS = map[string]string{
+	"きゃ": "kya", "きゅ": "kyu", "きょ": "kyo", "しゃ": "sha", "しゅ": "shu", "しょ": "sho",
+	"ちゃ": "cha", "ちゅ": "chu", "ちょ": "cho", "にゃ": "nya", "にゅ": "nyu", "にょ": "nyo",
+	"ひゃ": "hya", "ひゅ": "hyu", "ひょ": "hyo", "みゃ": "mya", "みゅ": "myu", "みょ": "myo",
+	"りゃ": "rya", "りゅ": "ryu", "りょ": "ryo", "ぎゃ": "gya", "ぎゅ": "gyu", "ぎょ": "gyo",
+	"じゃ": "ja", "じゅ": "ju", "じょ": "jo", "びゃ": "bya", "びゅ": "byu", "びょ": "byo",
+	"ぴゃ": "pya", "ぴゅ": "pyu", "ぴょ": "pyo",
+	"ふぁ": "fa", "ふぃ": "fi", "ふぇ": "fe", "ふぉ": "fo", "てぃ": "ti", "でぃ": "di",
+	"うぃ": "wi", "うぇ": "we", "うぉ": "wo", "ゔぁ": "va", "ゔぃ": "vi", "ゔぇ": "ve", "ゔぉ": "vo",
+	"しぇ": "she", "じぇ": "je", "ちぇ": "che",
+}
+
+var KANA = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ゔ': "vu", 'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ゎ': "wa",
+}
+
+// romanize_kana applies Hepburn romanization to hiragana and katakana,
+// katakana being mapped onto hiragana first.
+func romanize_kana(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if r >= 'ァ' && r <= 'ヶ' {
+			runes[i] = r - 'ァ' + 'ぁ'
+		}
+	}
+	var b strings.Builder
+	doubleNext := false
+	last := ""
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		roman := ""
+		if i+1 < len(runes) {
+			if digraph, ok := KANA_DIGRAPHS[string(runes[i:i+2])]; ok {
+				roman = digraph
+				i++
+			}
+		}
+		switch {
+		case roman != "":
+		case r == 'っ':
+			doubleNext = true
+			continue
+		case r == 'ー':
+			if last != "" {
+				roman = last[len(last)-1:]
+			}
+		case r == '・':
+			roman = " "
+		default:
+			roman = KANA[r]
+			if roman == "" {
+				b.WriteRune(r)
+				last = ""
+				doubleNext = false
+				continue
+			}
+		}
+		if doubleNext && roman[0] != 'a' && roman[0] != 'i' && roman[0] != 'u' && roman[0] != 'e' && roman[0] != 'o' {
+			if strings.HasPrefix(roman, "ch") {
+				b.WriteByte('t')
+			} else {
+				b.WriteByte(roman[0])
+			}
+		}
+		doubleNext = false
+		b.WriteString(roman)
+		last = roman
+	}
+	return b.String()
+}
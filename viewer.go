@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// open_in_viewer downloads the images to a temporary directory, named after
+// their choice number, and opens them in the user's image viewer without
+// waiting for it so the prompt stays usable.
+func open_in_viewer(viewer string, urls []string) error {
+	args := strings.Fields(viewer)
+	if len(args) == 0 {
+		return errors.New("no image viewer configured, use --viewer")
+	}
+	dir, err := os.MkdirTemp("", "lutris-cover-art-preview-*")
+	if err != nil {
+		return err
+	}
+	var files []string
+	for i, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		file := filepath.Join(dir, fmt.Sprint(i+1, path.Ext(parsed.Path)))
+		if err := download_preview(u, file); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		files = append(files, file)
+	}
+
+	cmd := exec.Command(args[0], append(args[1:], files...)...)
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	go func() {
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}()
+	return nil
+}
+
+// download_preview goes through the retries of the other downloads, error
+// pages never being saved as images.
+func download_preview(u, file string) error {
+	body, err := http_get(context.Background(), u, false)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, body, 0o644)
+}
+
+func grid_thumbs(grids []grid) []string {
+	thumbs := make([]string, 0, len(grids))
+	for _, grid := range grids {
+		if grid.Thumb != "" {
+			thumbs = append(thumbs, grid.Thumb)
+		} else {
+			thumbs = append(thumbs, grid.Url)
+		}
+	}
+	return thumbs
+}
+
+func preview_game_grids(viewer string, gameId int, diverse bool) error {
+	grids, err := fetch_steamgriddb_grids(gameId)
+	if err != nil {
+		return err
+	}
+	if diverse {
+		grids = diversify(grids)
+	}
+	return open_in_viewer(viewer, grid_thumbs(grids[:min(len(grids), INTERACTIVE_MAX_CHOICES)]))
+}
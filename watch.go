@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const JOURNAL_FILE = "journal.json"
+const DEFAULT_WATCH_DEBOUNCE = 2 * time.Second
+
+// journal remembers the newest rows already processed in watch mode so each
+// wake-up only looks at games added or changed since.
+type journal struct {
+	MaxRowid   int64 `json:"max_rowid"`
+	MaxUpdated int64 `json:"max_updated"`
+}
+
+func run_watch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	flags := add_fetch_flags(fs)
+	debounce := fs.Duration("debounce", DEFAULT_WATCH_DEBOUNCE, "how long to wait for Lutris to finish writing before scanning")
+	parse_flags(fs, args)
+	if flag_set(fs, "profile") {
+		log.Fatal("Invalid --profile value", "err", "watch runs until stopped, profile a fetch instead")
+	}
+	opts := flags.options()
+
+	lutrisDirs, db := open_lutris(librarySource{})
+	defer db.Close()
+
+	var j journal
+	if err := read_state_file(JOURNAL_FILE, &j); err != nil {
+		log.Fatal("An error occurred while loading the watch journal", "err", err)
+	}
+	watcher, err := new_db_watcher(lutrisDirs.DbFilePath)
+	if err != nil {
+		log.Fatal("An error occurred while watching Lutris database", "err", err)
+	}
+	defer watcher.close()
+
+	run := new_fetch_run(opts, lutrisDirs)
+	log.Info("Watching Lutris database for new games", "db", lutrisDirs.DbFilePath)
+	for {
+		scan_changed_games(db, &j, run)
+		if err := watcher.wait(); err != nil {
+			log.Fatal("An error occurred while watching Lutris database", "err", err)
+		}
+		// Lutris writes a game in several statements, let it settle
+		time.Sleep(*debounce)
+		watcher.drain()
+	}
+}
+
+// scan_changed_games processes the games changed since the journal, which is
+// only advanced past the games that went through, so those failing, e.g.
+// rate limited, are scanned again on the next wake-up.
+func scan_changed_games(db *sql.DB, j *journal, run *fetchRun) {
+	games, err := select_changed_games(db, *j)
+	if err != nil {
+		log.Error("Error while fetching changed games", "err", err)
+		return
+	}
+	next := *j
+	for _, g := range games {
+		next.MaxRowid = max(next.MaxRowid, g.Id)
+		next.MaxUpdated = max(next.MaxUpdated, g.Updated)
+	}
+	plan := plan_assets(run.dirs, run.opts, run.manifest, run.opts.Where.filter(games))
+	if len(plan.Items) > 0 {
+		log.Info(fmt.Sprintf("%d new or changed games are missing %d assets", len(plan.Items), plan.pairs()))
+		run.summary = new_run_summary()
+		run.process_plan(plan)
+		run.commit_db_writes(db)
+		run.summary.print(run.opts.all_assets(), run.opts.DryRun)
+		if !run.opts.DryRun {
+			run.save()
+		}
+		failed := map[gameSlug]bool{}
+		for _, e := range run.summary.Failed {
+			failed[e.Slug] = true
+		}
+		for _, g := range games {
+			if !failed[g.Slug] {
+				continue
+			}
+			// keep whichever of its row or update got the game selected
+			if g.Updated > j.MaxUpdated {
+				next.MaxUpdated = min(next.MaxUpdated, g.Updated-1)
+			} else {
+				next.MaxRowid = min(next.MaxRowid, g.Id-1)
+			}
+		}
+	}
+	*j = next
+	if run.opts.DryRun {
+		return
+	}
+	if err := write_state_file(JOURNAL_FILE, j); err != nil {
+		log.Error("Error while saving the watch journal", "err", err)
+	}
+}
+
+func select_changed_games(db *sql.DB, j journal) ([]game, error) {
+	return query_games(db, "SELECT * FROM ("+GAMES_QUERY+") WHERE row_id > ? OR updated_at > ?", j.MaxRowid, j.MaxUpdated)
+}
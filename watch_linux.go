@@ -0,0 +1,75 @@
+//go:build linux
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// dbWatcher watches the directory holding pga.db, as SQLite replaces and
+// appends to its -wal and -journal siblings rather than the database itself.
+type dbWatcher struct {
+	fd     int
+	dbName string
+}
+
+func new_db_watcher(dbPath string) (*dbWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_MOVED_TO | syscall.IN_CREATE)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(dbPath), mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &dbWatcher{fd: fd, dbName: filepath.Base(dbPath)}, nil
+}
+
+func (w *dbWatcher) wait() error {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if w.concerns_db(buf[:n]) {
+			return nil
+		}
+	}
+}
+
+func (w *dbWatcher) concerns_db(events []byte) bool {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(events); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&events[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		name := strings.TrimRight(string(events[nameStart:nameStart+int(event.Len)]), "\x00")
+		if strings.HasPrefix(name, w.dbName) {
+			return true
+		}
+		offset = nameStart + int(event.Len)
+	}
+	return false
+}
+
+// drain discards the events queued while waiting for Lutris to settle.
+func (w *dbWatcher) drain() {
+	syscall.SetNonblock(w.fd, true)
+	defer syscall.SetNonblock(w.fd, false)
+	buf := make([]byte, 4096)
+	for {
+		if n, err := syscall.Read(w.fd, buf); err != nil || n <= 0 {
+			return
+		}
+	}
+}
+
+func (w *dbWatcher) close() {
+	syscall.Close(w.fd)
+}
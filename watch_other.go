@@ -0,0 +1,65 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const WATCH_POLL_INTERVAL = 5 * time.Second
+
+// dbWatcher polls the modification times of pga.db and its SQLite siblings
+// where inotify is not available.
+type dbWatcher struct {
+	dbPath string
+	seen   time.Time
+}
+
+func new_db_watcher(dbPath string) (*dbWatcher, error) {
+	w := &dbWatcher{dbPath: dbPath}
+	seen, err := w.latest_change()
+	w.seen = seen
+	return w, err
+}
+
+func (w *dbWatcher) latest_change() (time.Time, error) {
+	var latest time.Time
+	entries, err := os.ReadDir(filepath.Dir(w.dbPath))
+	if err != nil {
+		return latest, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), filepath.Base(w.dbPath)) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (w *dbWatcher) wait() error {
+	for {
+		time.Sleep(WATCH_POLL_INTERVAL)
+		latest, err := w.latest_change()
+		if err != nil {
+			return err
+		}
+		if latest.After(w.seen) {
+			w.seen = latest
+			return nil
+		}
+	}
+}
+
+func (w *dbWatcher) drain() {
+	if latest, err := w.latest_change(); err == nil {
+		w.seen = latest
+	}
+}
+
+func (w *dbWatcher) close() {}
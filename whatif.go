@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+)
+
+// whatIf is what a fetch would do for a game that is not in the library.
+type whatIf struct {
+	Game   game
+	Match  string
+	Images map[assetType]bool
+}
+
+// run_plan goes through the matching and image selection of a fetch for
+// hypothetical games, e.g. the titles of a ROM set before importing it, to
+// gauge the art they would get. Nothing is written, not even curation or
+// match statistics.
+func run_plan(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	flags := add_fetch_flags(fs)
+	var titles []string
+	fs.Func("add", "title of a game to plan for as if it was added to Lutris, can be repeated", func(title string) error {
+		if strings.TrimSpace(title) == "" {
+			return fmt.Errorf("empty title")
+		}
+		titles = append(titles, strings.TrimSpace(title))
+		return nil
+	})
+	addFile := fs.String("add-file", "", "file listing the titles of the games to plan for, one per line")
+	runner := fs.String("runner", "", "runner the games would be added with, picking its runner_assets")
+	parse_flags(fs, args)
+	opts := flags.options()
+	if *addFile != "" {
+		listed, err := read_titles(*addFile)
+		if err != nil {
+			log.Fatal("An error occurred while reading titles", "err", err)
+		}
+		titles = append(titles, listed...)
+	}
+	if len(titles) == 0 {
+		log.Fatal("Usage: plan --add <title>... | plan --add-file <file>")
+	}
+
+	SGDB_API_KEY = os.Getenv("SGDB_API_KEY")
+	if SGDB_API_KEY == "" {
+		log.Fatal("Please set the SGDB_API_KEY environment variable with your StreamGridDB API key")
+	}
+	cur, err := load_curation()
+	if err != nil {
+		log.Fatal("An error occurred while loading curation decisions", "err", err)
+	}
+
+	kinds := opts.assets_for(game{Runner: gameRunner(*runner)})
+	var plans []whatIf
+	for _, title := range titles {
+		g := game{Slug: lutris_slug(title), Name: title, Runner: gameRunner(*runner)}
+		if !opts.Where.match(g) {
+			continue
+		}
+		plans = append(plans, plan_game(cur, opts, g, kinds))
+	}
+	if len(plans) == 0 {
+		log.Info("No title matches --where")
+		return
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "TITLE\tMATCH"
+	for _, kind := range kinds {
+		header += "\t" + strings.ToUpper(string(kind.Name))
+	}
+	fmt.Fprintln(out, header)
+	for _, p := range plans {
+		line := p.Game.Name + "\t" + p.Match
+		for _, kind := range kinds {
+			cell := "-"
+			if p.Images[kind.Name] {
+				cell = "yes"
+			}
+			line += "\t" + cell
+		}
+		fmt.Fprintln(out, line)
+	}
+	out.Flush()
+
+	matched := 0
+	for _, p := range plans {
+		if p.Images != nil {
+			matched++
+		}
+	}
+	log.Info(fmt.Sprintf("%d of %d games would be matched", matched, len(plans)))
+	for _, kind := range kinds {
+		found := 0
+		for _, p := range plans {
+			if p.Images[kind.Name] {
+				found++
+			}
+		}
+		log.Info(kind.Name, "games", found, "coverage", fmt.Sprintf("%.0f%%", 100*float64(found)/float64(len(plans))))
+	}
+}
+
+// plan_game matches the game and picks its images the way a fetch would,
+// quarantined matches getting no image. Images is only set for matched games.
+func plan_game(cur *curation, opts fetchOptions, g game, kinds []assetKind) whatIf {
+	p := whatIf{Game: g}
+	if images, ok := opts.Catalog.images(g.Slug); ok {
+		p.Match = "tool catalog"
+		p.Images = map[assetType]bool{}
+		for _, kind := range kinds {
+			p.Images[kind.Name] = images[kind.Name] != "" || cur.override_url(g.Slug, kind.Name) != ""
+		}
+		return p
+	}
+	id, ok := cur.pinned(g.Slug)
+	if ok {
+		p.Match = fmt.Sprintf("#%d, pinned", id)
+	} else {
+		candidates, err := search_candidates(g, cur, &strategyStats{Strategies: map[string]*strategyCounters{}}, opts.Race)
+		if err != nil {
+			log.Error("Error while retrieving SteamGridDB game ID", "game", g.Name, "code", error_code(err), "err", err)
+			p.Match = "error, " + error_code(err)
+			return p
+		}
+		if len(candidates) == 0 {
+			p.Match = "none"
+			return p
+		}
+		best := candidates[0]
+		if reason := match_doubt(candidates); reason != "" {
+			p.Match = fmt.Sprintf("%s #%d, %.0f%%, would be quarantined (%s)", best.Game.Name, best.Game.Id, best.Confidence*100, reason)
+			return p
+		}
+		id = best.Game.Id
+		p.Match = fmt.Sprintf("%s #%d, %.0f%% via %s", best.Game.Name, id, best.Confidence*100, best.Strategy)
+	}
+
+	p.Images = map[assetType]bool{}
+	images := map[string][]grid{}
+	for _, kind := range kinds {
+		if cur.override_url(g.Slug, kind.Name) != "" {
+			p.Images[kind.Name] = true
+			continue
+		}
+		if _, fetched := images[kind.Endpoint]; !fetched {
+			var err error
+			images[kind.Endpoint], err = fetch_steamgriddb_images(kind.Endpoint, id, endpoint_dimensions(kinds, kind.Endpoint))
+			if err != nil && error_code(err) != E_NO_IMAGE {
+				log.Warn("Error while retrieving SteamGridDB "+kind.Endpoint, "game", g.Name, "code", error_code(err), "err", err)
+			}
+		}
+		p.Images[kind.Name] = select_image(images[kind.Endpoint], kind) != nil
+	}
+	return p
+}
+
+func read_titles(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var titles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if title := strings.TrimSpace(scanner.Text()); title != "" {
+			titles = append(titles, title)
+		}
+	}
+	return titles, scanner.Err()
+}
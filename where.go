@@ -0,0 +1,395 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// gameFilter is a --where expression, e.g.
+// `runner == "wine" && !hidden && lastplayed > 2024-01-01`, selecting the
+// games a command works on. A nil filter selects every game. and, or and not
+// can be written instead of &&, || and !.
+type gameFilter struct {
+	eval func(g game) filterValue
+}
+
+type filterType int
+
+const (
+	FILTER_BOOL filterType = iota
+	FILTER_NUMBER
+	FILTER_STRING
+	FILTER_LIST
+	FILTER_DURATION
+)
+
+func (t filterType) String() string {
+	return [...]string{"boolean", "number", "string", "list", "duration"}[t]
+}
+
+type filterValue struct {
+	b bool
+	// n is the number, or the duration in seconds.
+	n float64
+	s string
+	l []string
+}
+
+// filterField is a field of game an expression can look at.
+type filterField struct {
+	Type filterType
+	Get  func(g game) filterValue
+}
+
+// FILTER_FIELDS are named after the fields of game, lowercased, underscores
+// being ignored so service_id is ServiceId. Dates are Unix timestamps,
+// compared with date literals such as 2024-01-01, and the playtime a duration
+// compared with duration literals such as 2h30m.
+var FILTER_FIELDS = map[string]filterField{
+	"id":         {FILTER_NUMBER, func(g game) filterValue { return filterValue{n: float64(g.Id)} }},
+	"slug":       {FILTER_STRING, func(g game) filterValue { return filterValue{s: string(g.Slug)} }},
+	"name":       {FILTER_STRING, func(g game) filterValue { return filterValue{s: g.Name} }},
+	"service":    {FILTER_STRING, func(g game) filterValue { return filterValue{s: string(g.Service)} }},
+	"serviceid":  {FILTER_STRING, func(g game) filterValue { return filterValue{s: g.ServiceId} }},
+	"runner":     {FILTER_STRING, func(g game) filterValue { return filterValue{s: string(g.Runner)} }},
+	"updated":    {FILTER_NUMBER, func(g game) filterValue { return filterValue{n: float64(g.Updated)} }},
+	"lastplayed": {FILTER_NUMBER, func(g game) filterValue { return filterValue{n: float64(g.LastPlayed)} }},
+	"playtime":   {FILTER_DURATION, func(g game) filterValue { return filterValue{n: g.Playtime * 3600} }},
+	"categories": {FILTER_LIST, func(g game) filterValue { return filterValue{l: g.Categories} }},
+	"hidden":     {FILTER_BOOL, func(g game) filterValue { return filterValue{b: g.Hidden} }},
+}
+
+// parse_game_filter returns nil for an empty expression.
+func parse_game_filter(source string) (*gameFilter, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	tokens, err := lex_filter(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != TOKEN_END {
+		return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+	}
+	if expr.Type != FILTER_BOOL {
+		return nil, fmt.Errorf("the expression is a %s, not a condition", expr.Type)
+	}
+	return &gameFilter{eval: expr.Get}, nil
+}
+
+func (f *gameFilter) match(g game) bool {
+	return f == nil || f.eval(g).b
+}
+
+// filter keeps the games matching the expression.
+func (f *gameFilter) filter(games []game) []game {
+	if f == nil {
+		return games
+	}
+	return slices.DeleteFunc(slices.Clone(games), func(g game) bool { return !f.match(g) })
+}
+
+type tokenKind int
+
+const (
+	TOKEN_END tokenKind = iota
+	TOKEN_IDENT
+	TOKEN_NUMBER
+	TOKEN_DURATION
+	TOKEN_STRING
+	TOKEN_OPERATOR
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+	// value is the number of numbers and dates, the seconds of durations,
+	// the text of strings.
+	value filterValue
+}
+
+var FILTER_OPERATORS = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")"}
+var FILTER_DATE = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+var FILTER_NUMBER_LITERAL = regexp.MustCompile(`^\d+(\.\d+)?`)
+
+// FILTER_DURATION_LITERAL are numbers of days, hours, minutes and seconds,
+// e.g. 90m or 1d12h, the unit keeping them from being numbers.
+var FILTER_DURATION_LITERAL = regexp.MustCompile(`^(\d+(\.\d+)?[dhms])+\b`)
+var FILTER_DURATION_PART = regexp.MustCompile(`(\d+(?:\.\d+)?)([dhms])`)
+var FILTER_DURATION_UNITS = map[string]float64{"d": 86400, "h": 3600, "m": 60, "s": 1}
+
+// FILTER_KEYWORDS are the words standing for the logical operators.
+var FILTER_KEYWORDS = map[string]string{"and": "&&", "or": "||", "not": "!"}
+
+func lex_filter(source string) ([]filterToken, error) {
+	var tokens []filterToken
+	for pos := 0; pos < len(source); {
+		rest := source[pos:]
+		c := rune(rest[0])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case c == '"' || c == '\'':
+			// double quoted strings take Go escapes, single quoted ones none
+			end := 1
+			for end < len(rest) && rest[end] != rest[0] {
+				if c == '"' && rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return nil, fmt.Errorf("unterminated string at %d", pos)
+			}
+			text := rest[:end+1]
+			s := text[1 : len(text)-1]
+			if c == '"' {
+				var err error
+				if s, err = strconv.Unquote(text); err != nil {
+					return nil, fmt.Errorf("invalid string at %d: %w", pos, err)
+				}
+			}
+			tokens = append(tokens, filterToken{TOKEN_STRING, text, pos, filterValue{s: s}})
+			pos += len(text)
+		case FILTER_DATE.MatchString(rest):
+			text := FILTER_DATE.FindString(rest)
+			date, err := time.ParseInLocation(time.DateOnly, text, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date at %d: %w", pos, err)
+			}
+			tokens = append(tokens, filterToken{TOKEN_NUMBER, text, pos, filterValue{n: float64(date.Unix())}})
+			pos += len(text)
+		case FILTER_DURATION_LITERAL.MatchString(rest):
+			text := FILTER_DURATION_LITERAL.FindString(rest)
+			var seconds float64
+			for _, part := range FILTER_DURATION_PART.FindAllStringSubmatch(text, -1) {
+				n, _ := strconv.ParseFloat(part[1], 64)
+				seconds += n * FILTER_DURATION_UNITS[part[2]]
+			}
+			tokens = append(tokens, filterToken{TOKEN_DURATION, text, pos, filterValue{n: seconds}})
+			pos += len(text)
+		case unicode.IsDigit(c):
+			text := FILTER_NUMBER_LITERAL.FindString(rest)
+			n, _ := strconv.ParseFloat(text, 64)
+			tokens = append(tokens, filterToken{TOKEN_NUMBER, text, pos, filterValue{n: n}})
+			pos += len(text)
+		case unicode.IsLetter(c) || c == '_':
+			end := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' })
+			if end < 0 {
+				end = len(rest)
+			}
+			tokens = append(tokens, filterToken{kind: TOKEN_IDENT, text: rest[:end], pos: pos})
+			pos += end
+		default:
+			idx := slices.IndexFunc(FILTER_OPERATORS, func(op string) bool { return strings.HasPrefix(rest, op) })
+			if idx < 0 {
+				return nil, fmt.Errorf("unexpected %q at %d", c, pos)
+			}
+			tokens = append(tokens, filterToken{kind: TOKEN_OPERATOR, text: FILTER_OPERATORS[idx], pos: pos})
+			pos += len(FILTER_OPERATORS[idx])
+		}
+	}
+	return append(tokens, filterToken{kind: TOKEN_END, text: "end of expression", pos: len(source)}), nil
+}
+
+// filterParser is a recursive descent parser, type checking the expression
+// as it goes so mistakes are reported before any game is processed.
+type filterParser struct {
+	tokens []filterToken
+	next   int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) take() filterToken {
+	tok := p.tokens[p.next]
+	if tok.kind != TOKEN_END {
+		p.next++
+	}
+	return tok
+}
+
+// accept takes the next token when it is the operator or keyword, including
+// the words of FILTER_KEYWORDS for their operators.
+func (p *filterParser) accept(text string) bool {
+	tok := p.peek()
+	if tok.kind == TOKEN_IDENT && FILTER_KEYWORDS[strings.ToLower(tok.text)] == text {
+		p.next++
+		return true
+	}
+	if (tok.kind == TOKEN_OPERATOR || tok.kind == TOKEN_IDENT) && tok.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) or() (filterField, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right filterField
+		if right, err = p.and(); err == nil {
+			left, err = logical("||", left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) and() (filterField, error) {
+	left, err := p.unary()
+	for err == nil && p.accept("&&") {
+		var right filterField
+		if right, err = p.unary(); err == nil {
+			left, err = logical("&&", left, right)
+		}
+	}
+	return left, err
+}
+
+func logical(op string, left, right filterField) (filterField, error) {
+	if left.Type != FILTER_BOOL || right.Type != FILTER_BOOL {
+		return filterField{}, fmt.Errorf("%s needs conditions on both sides", op)
+	}
+	if op == "&&" {
+		return filterField{FILTER_BOOL, func(g game) filterValue { return filterValue{b: left.Get(g).b && right.Get(g).b} }}, nil
+	}
+	return filterField{FILTER_BOOL, func(g game) filterValue { return filterValue{b: left.Get(g).b || right.Get(g).b} }}, nil
+}
+
+func (p *filterParser) unary() (filterField, error) {
+	if p.accept("!") {
+		operand, err := p.unary()
+		if err != nil {
+			return operand, err
+		}
+		if operand.Type != FILTER_BOOL {
+			return filterField{}, fmt.Errorf("! needs a condition, not a %s", operand.Type)
+		}
+		return filterField{FILTER_BOOL, func(g game) filterValue { return filterValue{b: !operand.Get(g).b} }}, nil
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filterField, error) {
+	left, err := p.primary()
+	if err != nil {
+		return left, err
+	}
+	op := p.peek()
+	switch {
+	case op.kind == TOKEN_OPERATOR && slices.Contains([]string{"==", "!=", "<", "<=", ">", ">="}, op.text):
+		p.take()
+		right, err := p.primary()
+		if err != nil {
+			return right, err
+		}
+		return compare(op, left, right)
+	case op.kind == TOKEN_OPERATOR && (op.text == "=~" || op.text == "!~"):
+		p.take()
+		pattern := p.take()
+		if pattern.kind != TOKEN_STRING || left.Type != FILTER_STRING {
+			return filterField{}, fmt.Errorf("%s at %d needs a string on its left and a quoted regular expression on its right", op.text, op.pos)
+		}
+		re, err := regexp.Compile(pattern.value.s)
+		if err != nil {
+			return filterField{}, fmt.Errorf("invalid regular expression at %d: %w", pattern.pos, err)
+		}
+		negate := op.text == "!~"
+		return filterField{FILTER_BOOL, func(g game) filterValue { return filterValue{b: re.MatchString(left.Get(g).s) != negate} }}, nil
+	case op.kind == TOKEN_IDENT && op.text == "in":
+		p.take()
+		right, err := p.primary()
+		if err != nil {
+			return right, err
+		}
+		if left.Type != FILTER_STRING || (right.Type != FILTER_LIST && right.Type != FILTER_STRING) {
+			return filterField{}, fmt.Errorf("in at %d needs a string on its left and a list or string on its right", op.pos)
+		}
+		if right.Type == FILTER_LIST {
+			return filterField{FILTER_BOOL, func(g game) filterValue { return filterValue{b: slices.Contains(right.Get(g).l, left.Get(g).s)} }}, nil
+		}
+		return filterField{FILTER_BOOL, func(g game) filterValue {
+			return filterValue{b: strings.Contains(strings.ToLower(right.Get(g).s), strings.ToLower(left.Get(g).s))}
+		}}, nil
+	}
+	return left, nil
+}
+
+func compare(op filterToken, left, right filterField) (filterField, error) {
+	if left.Type != right.Type || left.Type == FILTER_LIST || (left.Type == FILTER_BOOL && op.text != "==" && op.text != "!=") {
+		return filterField{}, fmt.Errorf("cannot compare a %s with a %s using %s at %d", left.Type, right.Type, op.text, op.pos)
+	}
+	var order func(a, b filterValue) int
+	switch left.Type {
+	case FILTER_NUMBER, FILTER_DURATION:
+		order = func(a, b filterValue) int { return cmp.Compare(a.n, b.n) }
+	case FILTER_STRING:
+		order = func(a, b filterValue) int { return strings.Compare(a.s, b.s) }
+	default:
+		order = func(a, b filterValue) int {
+			if a.b == b.b {
+				return 0
+			}
+			return 1
+		}
+	}
+	test := map[string]func(int) bool{
+		"==": func(c int) bool { return c == 0 },
+		"!=": func(c int) bool { return c != 0 },
+		"<":  func(c int) bool { return c < 0 },
+		"<=": func(c int) bool { return c <= 0 },
+		">":  func(c int) bool { return c > 0 },
+		">=": func(c int) bool { return c >= 0 },
+	}[op.text]
+	return filterField{FILTER_BOOL, func(g game) filterValue { return filterValue{b: test(order(left.Get(g), right.Get(g)))} }}, nil
+}
+
+func (p *filterParser) primary() (filterField, error) {
+	tok := p.take()
+	switch tok.kind {
+	case TOKEN_OPERATOR:
+		if tok.text != "(" {
+			break
+		}
+		expr, err := p.or()
+		if err != nil {
+			return expr, err
+		}
+		if !p.accept(")") {
+			return filterField{}, fmt.Errorf("missing ) for the ( at %d", tok.pos)
+		}
+		return expr, nil
+	case TOKEN_NUMBER:
+		return filterField{FILTER_NUMBER, func(game) filterValue { return tok.value }}, nil
+	case TOKEN_DURATION:
+		return filterField{FILTER_DURATION, func(game) filterValue { return tok.value }}, nil
+	case TOKEN_STRING:
+		return filterField{FILTER_STRING, func(game) filterValue { return tok.value }}, nil
+	case TOKEN_IDENT:
+		switch name := strings.ToLower(strings.ReplaceAll(tok.text, "_", "")); name {
+		case "true", "false":
+			b := name == "true"
+			return filterField{FILTER_BOOL, func(game) filterValue { return filterValue{b: b} }}, nil
+		default:
+			if field, ok := FILTER_FIELDS[name]; ok {
+				return field, nil
+			}
+			return filterField{}, fmt.Errorf("unknown field %q at %d", tok.text, tok.pos)
+		}
+	}
+	return filterField{}, fmt.Errorf("unexpected %s at %d", tok.text, tok.pos)
+}
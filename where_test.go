@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func filter_test_games() []game {
+	date := func(s string) int64 {
+		t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+		if err != nil {
+			panic(err)
+		}
+		return t.Unix()
+	}
+	return []game{
+		{Id: 1, Slug: "celeste", Name: "Celeste", Service: SERVICE_STEAM, Runner: RUNNER_LINUX, LastPlayed: date("2024-03-01"), Playtime: 12.5, Categories: []string{"Platformer", "Favorites"}},
+		{Id: 2, Slug: "the-witcher-3", Name: "The Witcher 3", Service: SERVICE_GOG, Runner: RUNNER_WINE, LastPlayed: date("2023-06-15"), Playtime: 120, Categories: []string{"RPG"}},
+		{Id: 3, Slug: "hades", Name: "Hades", Service: SERVICE_EGS, Runner: RUNNER_WINE, LastPlayed: date("2024-01-01"), Playtime: 0.75, Hidden: true},
+		{Id: 4, Slug: "doom", Name: "DOOM", Runner: RUNNER_DOSBOX},
+	}
+}
+
+func TestGameFilter(t *testing.T) {
+	tests := []struct {
+		where string
+		want  string
+	}{
+		{``, "celeste the-witcher-3 hades doom"},
+		{`runner == "wine"`, "the-witcher-3 hades"},
+		{`runner != 'wine'`, "celeste doom"},
+		{`hidden`, "hades"},
+		{`!hidden`, "celeste the-witcher-3 doom"},
+		{`hidden == false`, "celeste the-witcher-3 doom"},
+		// && binds tighter than ||, ! tighter than both
+		{`runner == "linux" || runner == "wine" && hidden`, "celeste hades"},
+		{`(runner == "linux" || runner == "wine") && hidden`, "hades"},
+		{`!hidden && runner == "wine" || id == 4`, "the-witcher-3 doom"},
+		{`!(hidden || runner == "wine")`, "celeste doom"},
+		{`runner == "linux" or runner == "wine" and hidden`, "celeste hades"},
+		{`not hidden AND service == "gog"`, "the-witcher-3"},
+		{`not not hidden`, "hades"},
+		// dates are compared as timestamps, from midnight in local time
+		{`lastplayed > 2024-01-01`, "celeste"},
+		{`lastplayed >= 2024-01-01`, "celeste hades"},
+		{`lastplayed < 2024-01-01 && lastplayed > 0`, "the-witcher-3"},
+		{`lastplayed == 0`, "doom"},
+		// durations
+		{`playtime >= 10h`, "celeste the-witcher-3"},
+		{`playtime > 12h30m`, "the-witcher-3"},
+		{`playtime >= 12h30m`, "celeste the-witcher-3"},
+		{`playtime == 45m`, "hades"},
+		{`playtime > 4d`, "the-witcher-3"},
+		{`playtime < 1h && playtime > 0s`, "hades"},
+		{`playtime <= 0.5h`, "doom"},
+		// in
+		{`"RPG" in categories`, "the-witcher-3"},
+		{`"Favorites" in categories || "RPG" in categories`, "celeste the-witcher-3"},
+		{`"witcher" in name`, "the-witcher-3"},
+		{`"rpg" in categories`, ""},
+		// regular expressions
+		{`name =~ "^[A-Z]+$"`, "doom"},
+		{`slug =~ '^the-'`, "the-witcher-3"},
+		{`name !~ "(?i)e"`, "doom"},
+		{`service_id == "" && service == ""`, "doom"},
+		{`serviceid == ""`, "celeste the-witcher-3 hades doom"},
+	}
+	games := filter_test_games()
+	for _, tt := range tests {
+		f, err := parse_game_filter(tt.where)
+		if err != nil {
+			t.Errorf("parse_game_filter(%q): %v", tt.where, err)
+			continue
+		}
+		var slugs []string
+		for _, g := range f.filter(games) {
+			slugs = append(slugs, string(g.Slug))
+		}
+		if got := strings.Join(slugs, " "); got != tt.want {
+			t.Errorf("%s matched %q, want %q", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestGameFilterErrors(t *testing.T) {
+	tests := []struct {
+		where string
+		want  string
+	}{
+		{`runner == 3`, "cannot compare a string with a number using == at 7"},
+		{`playtime > 10`, "cannot compare a duration with a number using > at 9"},
+		{`lastplayed > 10h`, "cannot compare a number with a duration using > at 11"},
+		{`hidden < true`, "cannot compare a boolean with a boolean using < at 7"},
+		{`categories == "RPG"`, "cannot compare a list with a string using == at 11"},
+		{`platform == "linux"`, `unknown field "platform" at 0`},
+		{`runner`, "the expression is a string, not a condition"},
+		{`playtime`, "the expression is a duration, not a condition"},
+		{`!runner`, "! needs a condition, not a string"},
+		{`not playtime`, "! needs a condition, not a duration"},
+		{`hidden && runner`, "&& needs conditions on both sides"},
+		{`hidden or 1`, "|| needs conditions on both sides"},
+		{`id =~ "1"`, "=~ at 3 needs a string on its left and a quoted regular expression on its right"},
+		{`name =~ "("`, "invalid regular expression at 8"},
+		{`id in categories`, "in at 3 needs a string on its left and a list or string on its right"},
+		{`(hidden`, "missing ) for the ( at 0"},
+		{`hidden)`, `unexpected ")" at 6`},
+		{`name == "Celeste`, "unterminated string at 8"},
+		{`lastplayed > 2024-13-01`, "invalid date at 13"},
+		{`hidden $`, `unexpected '$' at 7`},
+		{`runner ==`, "unexpected end of expression at 9"},
+	}
+	for _, tt := range tests {
+		_, err := parse_game_filter(tt.where)
+		if err == nil {
+			t.Errorf("parse_game_filter(%q) succeeded, want %q", tt.where, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parse_game_filter(%q) = %q, want %q", tt.where, err, tt.want)
+		}
+	}
+}